@@ -0,0 +1,392 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+var (
+	// ErrBundleNotFound is returned by Create and Run when the bundle
+	// directory does not exist
+	ErrBundleNotFound = errors.New("bundle not found")
+	// ErrConfigMissing is returned by Create and Run when the bundle has no
+	// config.json
+	ErrConfigMissing = errors.New("bundle has no config.json")
+)
+
+// checkBundle checks that the bundle is a directory holding a config.json,
+// before runc is started. An empty bundle is the current directory, as it is
+// for runc.
+func checkBundle(bundle string) error {
+	dir := bundle
+	if dir == "" {
+		dir = "."
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrBundleNotFound, dir)
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrBundleNotFound, dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.json")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrConfigMissing, dir)
+		}
+		return err
+	}
+	return nil
+}
+
+// DefaultMaxConfigBytes is the size above which LoadSpec rejects the
+// config.json of a bundle before decoding it, guarding against huge or
+// corrupt configs
+const DefaultMaxConfigBytes = 16 << 20
+
+// ErrConfigTooLarge is returned by LoadSpec when the config.json of the
+// bundle is larger than the limit
+var ErrConfigTooLarge = errors.New("bundle config is too large")
+
+// LoadSpec reads the OCI runtime spec from the config.json of the bundle,
+// rejecting configs larger than DefaultMaxConfigBytes
+func LoadSpec(bundle string) (*specs.Spec, error) {
+	return LoadSpecLimit(bundle, DefaultMaxConfigBytes)
+}
+
+// LoadSpecLimit is LoadSpec with the config.json limited to max bytes
+// instead. Zero disables the limit.
+func LoadSpecLimit(bundle string, max int64) (*specs.Spec, error) {
+	path := filepath.Join(bundle, "config.json")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if max > 0 {
+		r = io.LimitReader(f, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(data)) > max {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrConfigTooLarge, path, max)
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle config: %w", err)
+	}
+	return &spec, nil
+}
+
+// SpecHash returns a stable hash of the content of the spec, as
+// "sha256:<hex>". Specs with the same content hash equally whatever the
+// formatting of their config.json, since the spec is hashed in its
+// canonical encoding/json form.
+func SpecHash(spec *specs.Spec) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// BundleHash returns the SpecHash of the spec of the bundle
+func BundleHash(bundle string) (string, error) {
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return "", err
+	}
+	return SpecHash(spec)
+}
+
+// RootfsPath returns the absolute path of the rootfs of the container, as
+// set by root.path in the spec of its bundle. A relative root.path is
+// relative to the bundle.
+func (r *Runc) RootfsPath(context context.Context, id string) (string, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return "", err
+	}
+	spec, err := LoadSpec(c.Bundle)
+	if err != nil {
+		return "", err
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return "", fmt.Errorf("bundle %s has no root path", c.Bundle)
+	}
+	path := spec.Root.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.Bundle, path)
+	}
+	return filepath.Abs(path)
+}
+
+// applyRuntimeClass transforms args with the RuntimeClasses entry selected
+// by the RuntimeClassAnnotation of the bundle spec. The args are returned
+// as is when the annotation is not set up or has no transform.
+func (r *Runc) applyRuntimeClass(bundle string, args []string) ([]string, error) {
+	if r.RuntimeClassAnnotation == "" || len(r.RuntimeClasses) == 0 {
+		return args, nil
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return nil, err
+	}
+	class, ok := spec.Annotations[r.RuntimeClassAnnotation]
+	if !ok {
+		return args, nil
+	}
+	if fn := r.RuntimeClasses[class]; fn != nil {
+		return fn(args), nil
+	}
+	return args, nil
+}
+
+// patchSpec sets string fields of the spec of the bundle, given by the
+// section holding them, as in {"linux": {"mountLabel": label}}. The rest of
+// the config is left as is, including the fields unknown to specs.Spec. The
+// config is only written if a field changes, and is replaced atomically.
+func patchSpec(bundle string, fields map[string]map[string]string) error {
+	path := filepath.Join(bundle, "config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse bundle config: %w", err)
+	}
+	changed := false
+	for name, values := range fields {
+		var section map[string]json.RawMessage
+		if raw, ok := config[name]; ok {
+			if err := json.Unmarshal(raw, &section); err != nil {
+				return fmt.Errorf("failed to parse bundle config: %s: %w", name, err)
+			}
+		}
+		if section == nil {
+			section = make(map[string]json.RawMessage)
+		}
+		for key, value := range values {
+			var current string
+			if raw, ok := section[key]; ok && json.Unmarshal(raw, &current) == nil && current == value {
+				continue
+			}
+			if section[key], err = json.Marshal(value); err != nil {
+				return err
+			}
+			changed = true
+		}
+		if config[name], err = json.Marshal(section); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if data, err = json.Marshal(config); err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(bundle, ".config.json.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// WriteBundle writes the spec as the config.json of the bundle directory,
+// creating the directory if needed
+func WriteBundle(bundle string, spec *specs.Spec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(bundle, 0o711); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bundle, "config.json"), data, 0o644)
+}
+
+// MinimalSpec returns a spec running args as the init of a container with
+// the read-only rootfs, in new namespaces without network access, like the
+// defaults of `runc spec`
+func MinimalSpec(rootfs string, args ...string) *specs.Spec {
+	caps := []string{"CAP_AUDIT_WRITE", "CAP_KILL", "CAP_NET_BIND_SERVICE"}
+	return &specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{
+			Args: args,
+			Env:  []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+			Cwd:  "/",
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  caps,
+				Effective: caps,
+				Permitted: caps,
+			},
+			NoNewPrivileges: true,
+		},
+		Root: &specs.Root{
+			Path:     rootfs,
+			Readonly: true,
+		},
+		Hostname: "runc",
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		},
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace},
+				{Type: specs.IPCNamespace},
+				{Type: specs.UTSNamespace},
+				{Type: specs.MountNamespace},
+			},
+			MaskedPaths: []string{
+				"/proc/acpi", "/proc/kcore", "/proc/keys", "/proc/latency_stats",
+				"/proc/timer_list", "/proc/timer_stats", "/proc/sched_debug",
+				"/proc/scsi", "/sys/firmware",
+			},
+			ReadonlyPaths: []string{
+				"/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+			},
+		},
+	}
+}
+
+// RunCommand runs args in a container with the rootfs, from a temporary
+// bundle holding the MinimalSpec of args, and returns the exit status and
+// output of the command like RunCaptured. The bundle is removed once the
+// container has exited.
+func (r *Runc) RunCommand(context context.Context, id, rootfs string, args []string, opts *CreateOpts) (int, []byte, []byte, error) {
+	rootfs, err := filepath.Abs(rootfs)
+	if err != nil {
+		return -1, nil, nil, err
+	}
+	bundle, err := os.MkdirTemp("", "runc-bundle")
+	if err != nil {
+		return -1, nil, nil, err
+	}
+	defer os.RemoveAll(bundle)
+	if err := WriteBundle(bundle, MinimalSpec(rootfs, args...)); err != nil {
+		return -1, nil, nil, err
+	}
+	return r.RunCaptured(context, id, bundle, opts)
+}
+
+// genericMountOptions are the mount options that are handled by the runtime
+// itself rather than passed down to the filesystem. Their recursive variants
+// are prefixed with "r".
+var genericMountOptions = map[string]struct{}{
+	"async": {}, "atime": {}, "bind": {}, "defaults": {}, "dev": {},
+	"diratime": {}, "dirsync": {}, "exec": {}, "idmap": {}, "iversion": {},
+	"lazytime": {}, "loud": {}, "mand": {}, "noatime": {}, "nodev": {},
+	"nodiratime": {}, "noexec": {}, "noiversion": {}, "nolazytime": {},
+	"nomand": {}, "norelatime": {}, "nostrictatime": {}, "nosuid": {},
+	"nosymfollow": {}, "private": {}, "relatime": {}, "remount": {}, "ro": {},
+	"rw": {}, "shared": {}, "silent": {}, "slave": {}, "strictatime": {},
+	"suid": {}, "symfollow": {}, "sync": {}, "tmpcopyup": {}, "unbindable": {},
+}
+
+func isGenericMountOption(o string) bool {
+	if _, ok := genericMountOptions[o]; ok {
+		return true
+	}
+	_, ok := genericMountOptions[strings.TrimPrefix(o, "r")]
+	return ok
+}
+
+// ValidateBundle checks the bundle's spec against the features reported by
+// the runtime before the container is created: the OCI version must be
+// within the supported range, and the mount options and seccomp profile
+// must only use what the runtime supports.
+//
+// Filesystem specific mount options are not validated.
+func (r *Runc) ValidateBundle(context context.Context, bundle string) error {
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return err
+	}
+	feat, err := r.cachedFeatures(context)
+	if err != nil {
+		return err
+	}
+
+	if spec.Version != "" {
+		if feat.OCIVersionMin != "" && compareVersions(spec.Version, feat.OCIVersionMin) < 0 ||
+			feat.OCIVersionMax != "" && compareVersions(spec.Version, feat.OCIVersionMax) > 0 {
+			return fmt.Errorf("bundle uses OCI runtime spec version %s but the runtime supports versions %s to %s",
+				spec.Version, feat.OCIVersionMin, feat.OCIVersionMax)
+		}
+	}
+	if feat.MountOptions != nil {
+		for _, m := range spec.Mounts {
+			for _, o := range m.Options {
+				if isGenericMountOption(o) && !supported(feat.MountOptions, o) {
+					return fmt.Errorf("mount option %q of %s is not supported by the runtime", o, m.Destination)
+				}
+			}
+		}
+	}
+	if spec.Linux != nil && spec.Linux.Seccomp != nil {
+		var s SeccompFeatures
+		if feat.Linux != nil && feat.Linux.Seccomp != nil {
+			s.Seccomp = *feat.Linux.Seccomp
+		}
+		if err := s.Validate(spec.Linux.Seccomp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
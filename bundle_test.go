@@ -0,0 +1,371 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// newTestBundle creates a bundle directory holding the spec as config.json
+func newTestBundle(t *testing.T, spec *specs.Spec) string {
+	t.Helper()
+	if spec == nil {
+		spec = &specs.Spec{Version: specs.Version}
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bundle, "config.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func TestValidateBundle(t *testing.T) {
+	ctx := context.Background()
+	r := newFeaturesRunc(t, testFeatures)
+
+	spec := &specs.Spec{
+		Version: "1.0.2-dev",
+		Mounts: []specs.Mount{
+			{Destination: "/dev/pts", Type: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "mode=0620"}},
+			{Destination: "/data", Type: "bind", Source: "/data", Options: []string{"rbind", "rro"}},
+		},
+		Linux: &specs.Linux{
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActErrno,
+				Syscalls: []specs.LinuxSyscall{
+					{Names: []string{"read"}, Action: specs.ActAllow},
+				},
+			},
+		},
+	}
+	if err := r.ValidateBundle(ctx, newTestBundle(t, spec)); err != nil {
+		t.Fatalf("unexpected error validating a supported bundle: %s", err)
+	}
+
+	spec.Version = "1.2.0"
+	err := r.ValidateBundle(ctx, newTestBundle(t, spec))
+	if err == nil || !strings.Contains(err.Error(), "1.2.0") {
+		t.Fatalf("expected error for incompatible OCI version, got %v", err)
+	}
+	spec.Version = "1.0.2-dev"
+
+	spec.Mounts[1].Options = append(spec.Mounts[1].Options, "rnosymfollow")
+	err = r.ValidateBundle(ctx, newTestBundle(t, spec))
+	if err == nil || !strings.Contains(err.Error(), "rnosymfollow") {
+		t.Fatalf("expected error for unsupported mount option, got %v", err)
+	}
+	spec.Mounts[1].Options = spec.Mounts[1].Options[:2]
+
+	spec.Linux.Seccomp.Syscalls = append(spec.Linux.Seccomp.Syscalls, specs.LinuxSyscall{
+		Names:  []string{"connect"},
+		Action: specs.ActNotify,
+	})
+	err = r.ValidateBundle(ctx, newTestBundle(t, spec))
+	if err == nil || !strings.Contains(err.Error(), string(specs.ActNotify)) {
+		t.Fatalf("expected error for unsupported seccomp action, got %v", err)
+	}
+}
+
+func TestRuncCreateLabels(t *testing.T) {
+	bundle := newTestBundle(t, &specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{Args: []string{"sh"}},
+	})
+	r := &Runc{
+		Command: newDummyRunc(t, "exit 0\n"),
+	}
+	if err := r.Create(context.Background(), "fake-id", bundle, &CreateOpts{
+		MountLabel:   "system_u:object_r:container_file_t:s0:c1,c2",
+		ProcessLabel: "system_u:system_r:container_t:s0:c1,c2",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Linux == nil || spec.Linux.MountLabel != "system_u:object_r:container_file_t:s0:c1,c2" {
+		t.Fatalf("expected the mount label in the spec, got %+v", spec.Linux)
+	}
+	if spec.Process.SelinuxLabel != "system_u:system_r:container_t:s0:c1,c2" {
+		t.Fatalf("expected the process label in the spec, got %q", spec.Process.SelinuxLabel)
+	}
+	if !reflect.DeepEqual(spec.Process.Args, []string{"sh"}) {
+		t.Fatalf("expected the rest of the spec to be kept, got %+v", spec.Process)
+	}
+}
+
+func TestRuncCreateLabelsPatch(t *testing.T) {
+	bundle := t.TempDir()
+	path := filepath.Join(bundle, "config.json")
+	config := `{"ociVersion":"1.0.2","process":{"args":["sh"],"x-future":true},"x-vendor":{"a":1}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	running := filepath.Join(t.TempDir(), "running")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+if [ "$1" = "state" ] && [ -e `+running+` ]; then
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+fi
+`),
+	}
+	opts := &CreateOpts{
+		MountLabel:     "system_u:object_r:container_file_t:s0:c1,c2",
+		IgnoreExisting: true,
+	}
+	if err := r.Create(context.Background(), "fake-id", bundle, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"x-future":true`, `"x-vendor":{"a":1}`, `"mountLabel":"system_u:object_r:container_file_t:s0:c1,c2"`} {
+		if !strings.Contains(string(data), field) {
+			t.Fatalf("expected %s in the config, got %s", field, data)
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the mode of the config to be kept, got %v", fi.Mode())
+	}
+
+	// the config is not written again when the labels are unchanged
+	if err := r.Create(context.Background(), "fake-id", bundle, opts); err != nil {
+		t.Fatal(err)
+	}
+	if nfi, err := os.Stat(path); err != nil || !os.SameFile(fi, nfi) {
+		t.Fatalf("expected the config to be left as is, got %v", err)
+	}
+
+	// nor when the container exists
+	if err := os.WriteFile(running, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts.MountLabel = "system_u:object_r:container_file_t:s0:c3,c4"
+	if err := r.Create(context.Background(), "fake-id", bundle, opts); err != nil {
+		t.Fatal(err)
+	}
+	if nfi, err := os.Stat(path); err != nil || !os.SameFile(fi, nfi) {
+		t.Fatalf("expected the config of the existing container to be left as is, got %v", err)
+	}
+}
+
+func TestRuncCreateRuntimeClass(t *testing.T) {
+	const annotation = "example.com/runtime-class"
+	argv := filepath.Join(t.TempDir(), "argv")
+	r := &Runc{
+		Command:                newDummyRunc(t, "echo \"$@\" > "+argv+"\n"),
+		RuntimeClassAnnotation: annotation,
+		RuntimeClasses: map[string]func([]string) []string{
+			"sandboxed": func(args []string) []string {
+				return append(args, "--no-pivot")
+			},
+		},
+	}
+	for _, tc := range []struct {
+		class    string
+		expected string
+	}{
+		{"sandboxed", "create --bundle %s --no-pivot fake-id\n"},
+		{"unknown", "create --bundle %s fake-id\n"},
+	} {
+		bundle := newTestBundle(t, &specs.Spec{
+			Version:     specs.Version,
+			Annotations: map[string]string{annotation: tc.class},
+		})
+		if err := r.Create(context.Background(), "fake-id", bundle, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(argv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf(tc.expected, bundle); string(data) != expected {
+			t.Fatalf("class %s: expected args %q, got %q", tc.class, expected, data)
+		}
+	}
+}
+
+func TestLoadSpecLimit(t *testing.T) {
+	bundle := newTestBundle(t, &specs.Spec{
+		Version:     specs.Version,
+		Annotations: map[string]string{"padding": strings.Repeat("x", 4096)},
+	})
+	if _, err := LoadSpecLimit(bundle, 1024); !errors.Is(err, ErrConfigTooLarge) {
+		t.Fatalf("expected ErrConfigTooLarge, got %v", err)
+	}
+	if _, err := LoadSpecLimit(bundle, 8192); err != nil {
+		t.Fatalf("expected the config within the limit to load, got %v", err)
+	}
+	if _, err := LoadSpecLimit(bundle, 0); err != nil {
+		t.Fatalf("expected no limit with zero, got %v", err)
+	}
+}
+
+func TestRuncRootfsPath(t *testing.T) {
+	for _, tc := range []struct {
+		root     string
+		expected func(bundle string) string
+	}{
+		{"rootfs", func(bundle string) string { return filepath.Join(bundle, "rootfs") }},
+		{"./layers/../rootfs", func(bundle string) string { return filepath.Join(bundle, "rootfs") }},
+		{"/var/lib/rootfs", func(string) string { return "/var/lib/rootfs" }},
+	} {
+		bundle := newTestBundle(t, &specs.Spec{
+			Version: specs.Version,
+			Root:    &specs.Root{Path: tc.root},
+		})
+		r := &Runc{
+			Command: newDummyRunc(t, `echo '{"id":"fake-id","status":"running","bundle":"`+bundle+`"}'`),
+		}
+		path, err := r.RootfsPath(context.Background(), "fake-id")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.root, err)
+		}
+		if expected := tc.expected(bundle); path != expected {
+			t.Errorf("%s: expected rootfs %s, got %s", tc.root, expected, path)
+		}
+	}
+
+	bundle := newTestBundle(t, nil)
+	r := &Runc{
+		Command: newDummyRunc(t, `echo '{"id":"fake-id","status":"running","bundle":"`+bundle+`"}'`),
+	}
+	if _, err := r.RootfsPath(context.Background(), "fake-id"); err == nil {
+		t.Fatal("expected an error without a root path")
+	}
+}
+
+func TestSpecHash(t *testing.T) {
+	spec := &specs.Spec{
+		Version:     specs.Version,
+		Process:     &specs.Process{Args: []string{"sh"}, Env: []string{"A=1"}},
+		Annotations: map[string]string{"b": "2", "a": "1"},
+	}
+	hash, err := SpecHash(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Fatalf("expected a sha256 hash, got %s", hash)
+	}
+
+	// the same spec formatted differently on disk
+	bundle := t.TempDir()
+	data, err := json.MarshalIndent(spec, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bundle, "config.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if bundleHash, err := BundleHash(bundle); err != nil || bundleHash != hash {
+		t.Fatalf("expected identical specs to hash equally, got %s and %s (%v)", hash, bundleHash, err)
+	}
+
+	spec.Process.Env = append(spec.Process.Env, "B=2")
+	if modified, err := SpecHash(spec); err != nil || modified == hash {
+		t.Fatalf("expected a modified spec to hash differently, got %s (%v)", modified, err)
+	}
+}
+
+func TestRuncCreateExConfigHash(t *testing.T) {
+	bundle := newTestBundle(t, nil)
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--pid-file" ]; then
+		printf 4242 > "$arg"
+	fi
+	prev=$arg
+done
+`),
+	}
+	result, err := r.CreateEx(context.Background(), "fake-id", bundle, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := BundleHash(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ConfigHash != expected {
+		t.Fatalf("expected config hash %s, got %s", expected, result.ConfigHash)
+	}
+}
+
+// helperRunBundle runs the process of the bundle like `runc run`, on the
+// host rather than in a container
+func helperRunBundle() {
+	var bundle string
+	for i, arg := range os.Args {
+		if arg == "--bundle" && i+1 < len(os.Args) {
+			bundle = os.Args[i+1]
+		}
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil || spec.Process == nil || len(spec.Process.Args) == 0 {
+		os.Exit(2)
+	}
+	if spec.Root == nil || !filepath.IsAbs(spec.Root.Path) {
+		os.Exit(3)
+	}
+	cmd := exec.Command(spec.Process.Args[0], spec.Process.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		os.Exit(4)
+	}
+	os.Exit(0)
+}
+
+func TestRuncRunCommand(t *testing.T) {
+	r := &Runc{
+		Command: newHelperRunc(t, "run-bundle"),
+	}
+	status, stdout, _, err := r.RunCommand(context.Background(), "fake-id", "rootfs", []string{"/bin/echo", "hello"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 0 || string(stdout) != "hello\n" {
+		t.Fatalf("expected status 0 and hello, got status %d and %q", status, stdout)
+	}
+}
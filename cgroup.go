@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// FreezerState is the state of the cgroup freezer of a container
+type FreezerState string
+
+const (
+	// Thawed is the state of a container which is not frozen
+	Thawed FreezerState = "THAWED"
+	// Freezing is the state of a container being frozen, some of its
+	// processes may still be running
+	Freezing FreezerState = "FREEZING"
+	// Frozen is the state of a container whose processes are all frozen
+	Frozen FreezerState = "FROZEN"
+)
+
+// FreezerState returns the state of the freezer of the container, read from
+// its cgroup. Unlike the paused status reported by State, it tells whether
+// the container is completely frozen yet.
+func (r *Runc) FreezerState(context context.Context, id string) (FreezerState, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return "", err
+	}
+	if c.Pid == 0 {
+		return "", fmt.Errorf("container %s is not running", id)
+	}
+	return processFreezerState(c.Pid)
+}
+
+// AvailableControllers returns the cgroup controllers available to the
+// container, which resources can be updated for. With cgroup v2, those are
+// the controllers in cgroup.controllers of the container's cgroup.
+func (r *Runc) AvailableControllers(context context.Context, id string) ([]string, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+	return processControllers(c.Pid)
+}
+
+// ReadResources returns the resource limits applied to the cgroup of the
+// container, as they would be passed to Update, allowing to check that an
+// update took effect. Only the memory, cpu and pids limits are read, and
+// the sections whose cgroup files are missing are left nil. Unlimited
+// values are reported as -1.
+//
+// With cgroup v2, the cpu shares are converted back from cpu.weight, which
+// is not exact for every value of the shares.
+func (r *Runc) ReadResources(context context.Context, id string) (*specs.LinuxResources, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+	return processResources(c.Pid)
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandSysProcAttr(t *testing.T) {
+	for _, tc := range []struct {
+		setpgid   bool
+		pdeathsig syscall.Signal
+	}{
+		{false, 0},
+		{true, 0},
+		{false, syscall.SIGKILL},
+		{true, syscall.SIGTERM},
+	} {
+		r := &Runc{Setpgid: tc.setpgid, PdeathSignal: tc.pdeathsig}
+		attr := r.command(context.Background(), "state", "fake-id").SysProcAttr
+		if attr.Setpgid != tc.setpgid || attr.Pdeathsig != tc.pdeathsig {
+			t.Errorf("setpgid %v, pdeathsig %v: got Setpgid %v and Pdeathsig %v", tc.setpgid, tc.pdeathsig, attr.Setpgid, attr.Pdeathsig)
+		}
+	}
+}
+
+func TestCommandPdeathsig(t *testing.T) {
+	for _, setpgid := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Setpgid=%v", setpgid), func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			command := newDummyRunc(t, "echo $$ > "+pidFile+".tmp\nmv "+pidFile+".tmp "+pidFile+"\nexec sleep 60\n")
+			parent := exec.Command(os.Args[0])
+			parent.Env = append(os.Environ(),
+				helperEnv+"=pdeathsig-parent",
+				"GO_RUNC_TEST_COMMAND="+command,
+				"GO_RUNC_TEST_SETPGID="+strconv.FormatBool(setpgid),
+			)
+			if err := parent.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer parent.Process.Kill()
+
+			pid := waitPidFile(t, pidFile)
+			pgid, err := syscall.Getpgid(pid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inOwnGroup := pgid == pid; inOwnGroup != setpgid {
+				t.Fatalf("expected runc to be in its own process group: %v, got pgid %d for pid %d", setpgid, pgid, pid)
+			}
+
+			parent.Process.Kill()
+			parent.Wait()
+			deadline := time.Now().Add(10 * time.Second)
+			for processAlive(pid) {
+				if time.Now().After(deadline) {
+					syscall.Kill(pid, syscall.SIGKILL)
+					t.Fatalf("expected runc to be killed once its parent has died")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// waitPidFile waits for the pid file to be written
+func waitPidFile(t *testing.T, path string) int {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return pid
+		}
+		if !errors.Is(err, os.ErrNotExist) || time.Now().After(deadline) {
+			t.Fatalf("waiting for %s: %v", path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processAlive returns whether the process exists and is not a zombie
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// the state follows the command, which is in parentheses
+	i := strings.LastIndexByte(string(data), ')')
+	return i < 0 || !strings.HasPrefix(string(data[i+1:]), " Z")
+}
@@ -25,11 +25,8 @@ import (
 )
 
 func (r *Runc) command(context context.Context, args ...string) *exec.Cmd {
-	command := r.Command
-	if command == "" {
-		command = DefaultCommand
-	}
-	cmd := exec.CommandContext(context, command, append(r.args(), args...)...)
+	argv := r.Argv(args...)
+	cmd := exec.CommandContext(context, argv[0], argv[1:]...)
 	cmd.Env = os.Environ()
 	return cmd
 }
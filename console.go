@@ -19,12 +19,17 @@
 package runc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/containerd/console"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/sys/unix"
 )
 
@@ -152,6 +157,27 @@ func (c *Socket) ReceiveMaster() (console.Console, error) {
 	return console.ConsoleFromFile(f)
 }
 
+// ConnectConsole dials the unix socket at path and receives the pty master
+// of a container from the process listening on it. It allows attaching to
+// the console of a container created with a console socket owned by another
+// process, which hands the master over the same way runc does.
+func ConnectConsole(path string) (console.Console, error) {
+	addr, err := net.ResolveUnixAddr("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	f, err := recvFd(conn)
+	if err != nil {
+		return nil, err
+	}
+	return console.ConsoleFromFile(f)
+}
+
 // Close closes the unix socket
 func (c *Socket) Close() error {
 	err := c.l.Close()
@@ -162,3 +188,132 @@ func (c *Socket) Close() error {
 	}
 	return err
 }
+
+// HandleResize applies every size received on sizes to the console until
+// sizes is closed or the context is done. It is meant to be run alongside
+// an interactive process whose terminal is resized over its lifetime, with
+// the console received from ReceiveMaster.
+func HandleResize(ctx context.Context, c console.Console, sizes <-chan console.WinSize) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case size, ok := <-sizes:
+			if !ok {
+				return nil
+			}
+			if err := c.Resize(size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ExecConsole executes the process inside the container with a terminal and
+// returns the pty master of the process once runc has sent it. The exec is
+// detached, as runc requires with a console socket, and the returned channel
+// receives nil once the process has exited, or the error of the context
+// once it is done, the process being killed then.
+//
+// The ConsoleSocket and Detach options are ignored, and spec.Terminal is
+// forced on. When opts has no PidFile, a temporary one is used.
+func (r *Runc) ExecConsole(context context.Context, id string, spec specs.Process, opts *ExecOpts) (console.Console, <-chan error, error) {
+	socket, err := NewTempConsoleSocket()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer socket.Close()
+
+	var o ExecOpts
+	if opts != nil {
+		o = *opts
+	}
+	o.ConsoleSocket = socket
+	o.Detach = true
+	if o.PidFile == "" {
+		// removed along with the directory of the socket
+		o.PidFile = filepath.Join(filepath.Dir(socket.Path()), "exec.pid")
+	}
+	spec.Terminal = true
+
+	type master struct {
+		c   console.Console
+		err error
+	}
+	masterc := make(chan master, 1)
+	go func() {
+		c, err := socket.ReceiveMaster()
+		masterc <- master{c, err}
+	}()
+	// runc sends the master before detaching
+	err = r.Exec(context, id, spec, &o)
+	pid := -1
+	if err == nil {
+		pid, err = ReadPidFile(o.PidFile)
+	}
+	// stop waiting for a master which won't be sent
+	socket.Close()
+	m := <-masterc
+	if err != nil {
+		if m.err == nil {
+			m.c.Close()
+		}
+		return nil, nil, err
+	}
+	if m.err != nil {
+		// nobody holds the terminal of the process
+		unix.Kill(pid, unix.SIGKILL)
+		return nil, nil, fmt.Errorf("exec started without sending the console: %w", m.err)
+	}
+	interval := r.StatusPollInterval
+	if interval <= 0 {
+		interval = defaultStatusPollInterval
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- waitProcess(context, pid, interval)
+	}()
+	return m.c, errc, nil
+}
+
+// waitProcess waits for the process, which is not a child, to exit by
+// checking its pidfd every interval or, without pidfd support, whether its
+// pid still exists. The process is killed once the context is done.
+func waitProcess(context context.Context, pid int, interval time.Duration) error {
+	fd, err := openPidfd(pid)
+	switch {
+	case errors.Is(err, syscall.ESRCH):
+		return nil
+	case err != nil && !errors.Is(err, syscall.ENOSYS):
+		return err
+	}
+	hasPidfd := err == nil
+	if hasPidfd {
+		defer fd.Close()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var exited bool
+		if hasPidfd {
+			if exited, err = fd.exited(); err != nil {
+				return err
+			}
+		} else {
+			exited = unix.Kill(pid, 0) == unix.ESRCH
+		}
+		if exited {
+			return nil
+		}
+		select {
+		case <-context.Done():
+			if hasPidfd {
+				fd.signal(syscall.SIGKILL)
+			} else {
+				unix.Kill(pid, unix.SIGKILL)
+			}
+			return context.Err()
+		case <-ticker.C:
+		}
+	}
+}
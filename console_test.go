@@ -17,11 +17,103 @@
 package runc
 
 import (
+	"context"
 	"errors"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/containerd/console"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
+// helperEnv selects the helper process the test binary runs as, in place
+// of runc, for the tests needing more than a shell script
+const helperEnv = "GO_RUNC_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "exec-console":
+		helperExecConsole()
+	case "exec-console-process":
+		helperExecConsoleProcess()
+	case "run-bundle":
+		helperRunBundle()
+	case "pdeathsig-parent":
+		helperPdeathsigParent()
+	}
+	os.Exit(1)
+}
+
+// newHelperRunc returns a runc stub running the test binary as the helper
+func newHelperRunc(t *testing.T, helper string) string {
+	return newDummyRunc(t, "exec env "+helperEnv+"="+helper+" "+os.Args[0]+" \"$@\"\n")
+}
+
+// helperExecConsole sends a pty master to the console socket like `runc
+// exec --detach` with a terminal, and leaves a process writing to the
+// terminal and running until it is killed, whose pid is in the pid file
+func helperExecConsole() {
+	var path, pidFile string
+	detach := false
+	for i, arg := range os.Args {
+		switch {
+		case arg == "--console-socket" && i+1 < len(os.Args):
+			path = os.Args[i+1]
+		case arg == "--pid-file" && i+1 < len(os.Args):
+			pidFile = os.Args[i+1]
+		case arg == "--detach":
+			detach = true
+		}
+	}
+	// runc refuses a console socket without detaching
+	if path == "" || pidFile == "" || !detach {
+		os.Exit(6)
+	}
+	pty, slave, err := console.NewPty()
+	if err != nil {
+		os.Exit(2)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		os.Exit(3)
+	}
+	if err := sendFd(conn, pty.Fd(), pty.Name()); err != nil {
+		os.Exit(4)
+	}
+	conn.Close()
+	pty.Close()
+	cmd := exec.Command(os.Args[0], slave)
+	cmd.Env = append(os.Environ(), helperEnv+"=exec-console-process")
+	if err := cmd.Start(); err != nil {
+		os.Exit(5)
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0o600); err != nil {
+		os.Exit(7)
+	}
+	os.Exit(0)
+}
+
+// helperExecConsoleProcess stands in for the process executed by
+// helperExecConsole, writing to its terminal
+func helperExecConsoleProcess() {
+	f, err := os.OpenFile(os.Args[1], os.O_RDWR, 0)
+	if err != nil {
+		os.Exit(5)
+	}
+	f.Write([]byte("hello"))
+	time.Sleep(time.Minute)
+}
+
 func TestTempConsole(t *testing.T) {
 	if err := os.Setenv("XDG_RUNTIME_DIR", ""); err != nil {
 		t.Fatalf("failed to clear the XDG_RUNTIME_DIR env: %v", err)
@@ -75,3 +167,146 @@ func ensureSocketCleanup(t *testing.T, c *Socket, path string) {
 		t.Fatal("path still exists")
 	}
 }
+
+func TestHandleResize(t *testing.T) {
+	pty, _, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pty.Close()
+
+	sizes := make(chan console.WinSize)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- HandleResize(context.Background(), pty, sizes)
+	}()
+	for _, want := range []console.WinSize{
+		{Height: 24, Width: 80},
+		{Height: 50, Width: 132},
+	} {
+		sizes <- want
+		// the next send only happens once the previous size was applied
+		sizes <- want
+		got, err := pty.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Height != want.Height || got.Width != want.Width {
+			t.Fatalf("expected size %+v but got %+v", want, got)
+		}
+	}
+	close(sizes)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sendFd sends the file descriptor over the unix socket along with its
+// name, the same way runc sends the pty master
+func sendFd(conn *net.UnixConn, fd uintptr, name string) error {
+	oob := unix.UnixRights(int(fd))
+	_, _, err := conn.WriteMsgUnix([]byte(name), oob, nil)
+	return err
+}
+
+func TestConnectConsole(t *testing.T) {
+	pty, slave, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pty.Close()
+	if err := pty.Resize(console.WinSize{Height: 42, Width: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "console.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		errc <- sendFd(conn, pty.Fd(), slave)
+	}()
+
+	c, err := ConnectConsole(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	size, err := c.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Height != 42 || size.Width != 100 {
+		t.Fatalf("expected the size of the sent pty, got %+v", size)
+	}
+}
+
+func TestRuncExecConsole(t *testing.T) {
+	r := &Runc{
+		Command: newHelperRunc(t, "exec-console"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, errc, err := r.ExecConsole(ctx, "fake-id", specs.Process{Args: []string{"sh"}}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from ExecConsole: %s", err)
+	}
+	defer c.Close()
+
+	buf := make([]byte, len("hello"))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("expected to read hello from the console, got %q", buf)
+	}
+
+	select {
+	case err := <-errc:
+		t.Fatalf("expected the exec to run until cancelled, got %v", err)
+	default:
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the cancelled exec to fail, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the cancelled exec to terminate")
+	}
+}
+
+func TestRuncExecConsoleArgs(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo "$@" > `+args+`
+exit 1
+`),
+	}
+	if _, _, err := r.ExecConsole(context.Background(), "fake-id", specs.Process{Args: []string{"sh"}}, nil); err == nil {
+		t.Fatal("expected an error from the failing exec")
+	}
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range []string{"--detach", "--console-socket ", "--pid-file "} {
+		if !strings.Contains(string(data), arg) {
+			t.Fatalf("expected %s in the args, got %s", arg, data)
+		}
+	}
+}
@@ -18,13 +18,25 @@ package runc
 
 import "time"
 
+// The statuses of a container reported by runc
+const (
+	StatusCreating = "creating"
+	StatusCreated  = "created"
+	StatusRunning  = "running"
+	StatusPaused   = "paused"
+	StatusStopped  = "stopped"
+)
+
 // Container hold information for a runc container
 type Container struct {
 	ID          string            `json:"id"`
-	Pid         int               `json:"pid"`
+	Pid         int               `json:"pid"` // host pid of the init, see ContainerPid
 	Status      string            `json:"status"`
 	Bundle      string            `json:"bundle"`
 	Rootfs      string            `json:"rootfs"`
 	Created     time.Time         `json:"created"`
 	Annotations map[string]string `json:"annotations"`
+	// Owner is the user owning the container, as reported by runc for
+	// rootless containers
+	Owner string `json:"owner,omitempty"`
 }
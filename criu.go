@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultCriu is the criu binary looked up in $PATH when Runc.Criu is unset
+const DefaultCriu = "criu"
+
+// ErrParseCriuVersion is used when the criu version can't be parsed
+var ErrParseCriuVersion = errors.New("unable to parse criu version")
+
+// CriuVersion returns the version of the criu binary used by checkpoint and
+// restore, as reported by `criu --version`
+func (r *Runc) CriuVersion(context context.Context) (string, error) {
+	criu := r.Criu
+	if criu == "" {
+		criu = DefaultCriu
+	}
+	data, err := r.cmdOutput(context, exec.CommandContext(context, criu, "--version"), true, nil)
+	defer putBuf(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, data.String())
+	}
+	return parseCriuVersion(data.String())
+}
+
+// parseCriuVersion parses the output of `criu --version`, such as:
+//
+//	Version: 3.17.1
+//	GitID: v3.17.1-dirty
+func parseCriuVersion(out string) (string, error) {
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Version:"); ok {
+			if v = strings.TrimSpace(v); v != "" {
+				return v, nil
+			}
+		}
+	}
+	return "", ErrParseCriuVersion
+}
+
+// CriuAtLeast returns whether the criu version is need or newer. It allows
+// gating checkpoint and restore features on the criu version before
+// attempting them.
+func (r *Runc) CriuAtLeast(context context.Context, need string) (bool, error) {
+	v, err := r.CriuVersion(context)
+	if err != nil {
+		return false, err
+	}
+	return compareVersions(v, need) >= 0, nil
+}
@@ -0,0 +1,67 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseCriuVersion(t *testing.T) {
+	for _, tc := range []struct {
+		out      string
+		expected string
+	}{
+		{"Version: 3.17.1\n", "3.17.1"},
+		{"Version: 3.18\nGitID: v3.18-12-g2fc2b7b\n", "3.18"},
+		{"Warn: something\nVersion: 4.0\n", "4.0"},
+	} {
+		v, err := parseCriuVersion(tc.out)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", tc.out, err)
+		}
+		if v != tc.expected {
+			t.Errorf("expected version %q, got %q", tc.expected, v)
+		}
+	}
+	if _, err := parseCriuVersion("criu: command not found"); !errors.Is(err, ErrParseCriuVersion) {
+		t.Fatalf("expected ErrParseCriuVersion, got %v", err)
+	}
+}
+
+func TestCriuAtLeast(t *testing.T) {
+	r := &Runc{
+		Criu: newDummyRunc(t, "echo 'Version: 3.17.1'\necho 'GitID: v3.17.1'\n"),
+	}
+	v, err := r.CriuVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "3.17.1" {
+		t.Fatalf("expected version 3.17.1, got %q", v)
+	}
+	for need, expected := range map[string]bool{"3.16": true, "3.17.1": true, "3.18": false} {
+		ok, err := r.CriuAtLeast(context.Background(), need)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Errorf("CriuAtLeast(%q): expected %v, got %v", need, expected, ok)
+		}
+	}
+}
@@ -16,6 +16,8 @@
 
 package runc
 
+import "encoding/json"
+
 // Event is a struct to pass runc event information
 type Event struct {
 	// Type are the event type generated by runc
@@ -26,6 +28,68 @@ type Event struct {
 	Stats *Stats `json:"data,omitempty"`
 	// Err has a read error if we were unable to decode the event from runc
 	Err error `json:"-"`
+	// Raw is the event as sent by runc, including the fields unknown to
+	// Event. It is set for the events read from the events stream.
+	Raw json.RawMessage `json:"-"`
+}
+
+const (
+	// EventTypeStats is the type of the events carrying the container's
+	// statistics in Stats
+	EventTypeStats = "stats"
+	// EventTypeOOM is the type of the events sent when a process of the
+	// container has been killed by the OOM killer
+	EventTypeOOM = "oom"
+	// EventTypeError is the type of the events sent when the events could
+	// not be decoded, the error is in Err
+	EventTypeError = "error"
+)
+
+// IsStats returns whether the event carries statistics
+func (e *Event) IsStats() bool {
+	return e.Type == EventTypeStats
+}
+
+// IsOOM returns whether the event reports an OOM kill
+func (e *Event) IsOOM() bool {
+	return e.Type == EventTypeOOM
+}
+
+// IsError returns whether the event reports an error reading the events
+func (e *Event) IsError() bool {
+	return e.Type == EventTypeError
+}
+
+// EventHandlers dispatches events to the handler of their type. Handlers
+// left nil ignore the events of their type.
+type EventHandlers struct {
+	Stats func(id string, stats *Stats)
+	OOM   func(id string)
+	Error func(err error)
+	// Unknown handles the events of any other type
+	Unknown func(e *Event)
+}
+
+// Handle calls the handler matching the type of the event
+func (h *EventHandlers) Handle(e *Event) {
+	switch {
+	case e.IsStats():
+		if h.Stats != nil && e.Stats != nil {
+			h.Stats(e.ID, e.Stats)
+		}
+	case e.IsOOM():
+		if h.OOM != nil {
+			h.OOM(e.ID)
+		}
+	case e.IsError():
+		if h.Error != nil {
+			h.Error(e.Err)
+		}
+	default:
+		if h.Unknown != nil {
+			h.Unknown(e)
+		}
+	}
 }
 
 // Stats is statistical information from the runc process
@@ -36,6 +100,57 @@ type Stats struct {
 	Blkio             Blkio               `json:"blkio"`
 	Hugetlb           map[string]Hugetlb  `json:"hugetlb"`
 	NetworkInterfaces []*NetworkInterface `json:"network_interfaces"`
+	// Present has the sections which were measured, telling a zero value
+	// from a section left out because its controller is not available
+	Present StatsSection `json:"-"`
+}
+
+// StatsSection is a set of the sections of Stats
+type StatsSection uint
+
+const (
+	StatsCpu StatsSection = 1 << iota //revive:disable-line
+	StatsMemory
+	StatsPids
+	StatsBlkio
+	StatsHugetlb
+	StatsNetworkInterfaces
+)
+
+// statsSectionKeys are the JSON keys of the sections of Stats
+var statsSectionKeys = map[string]StatsSection{
+	"cpu":                StatsCpu,
+	"memory":             StatsMemory,
+	"pids":               StatsPids,
+	"blkio":              StatsBlkio,
+	"hugetlb":            StatsHugetlb,
+	"network_interfaces": StatsNetworkInterfaces,
+}
+
+// Has returns whether the section was measured
+func (s *Stats) Has(section StatsSection) bool {
+	return s.Present&section == section
+}
+
+// UnmarshalJSON decodes the stats and records the sections present in
+// data, a null section being absent
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	// stats has the fields of Stats, without its methods
+	type stats Stats
+	if err := json.Unmarshal(data, (*stats)(s)); err != nil {
+		return err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	s.Present = 0
+	for key, value := range sections {
+		if section, ok := statsSectionKeys[key]; ok && string(value) != "null" {
+			s.Present |= section
+		}
+	}
+	return nil
 }
 
 // Hugetlb represents the detailed hugetlb component of the statistics data
@@ -0,0 +1,141 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEventHugetlb(t *testing.T) {
+	// sample output of `runc events --stats`, trimmed to the hugetlb section
+	input := `{"type":"stats","id":"test","data":{"hugetlb":{"1GB":{"failcnt":0},"2MB":{"usage":4194304,"max":6291456,"failcnt":2}}}}`
+
+	var e Event
+	if err := json.Unmarshal([]byte(input), &e); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Hugetlb{
+		"1GB": {},
+		"2MB": {Usage: 4194304, Max: 6291456, Failcnt: 2},
+	}
+	if !reflect.DeepEqual(e.Stats.Hugetlb, expected) {
+		t.Fatalf("expected hugetlb %+v but got %+v", expected, e.Stats.Hugetlb)
+	}
+
+	data, err := json.Marshal(e.Stats.Hugetlb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if golden := `{"1GB":{"failcnt":0},"2MB":{"usage":4194304,"max":6291456,"failcnt":2}}`; string(data) != golden {
+		t.Fatalf("expected %s but got %s", golden, data)
+	}
+
+	var metrics []string
+	for _, m := range e.Stats.Metrics() {
+		if m.Labels["pagesize"] != "" {
+			metrics = append(metrics, m.String())
+		}
+	}
+	expectedMetrics := []string{
+		`hugetlb_usage{pagesize="1GB"} 0`,
+		`hugetlb_max{pagesize="1GB"} 0`,
+		`hugetlb_failcnt{pagesize="1GB"} 0`,
+		`hugetlb_usage{pagesize="2MB"} 4194304`,
+		`hugetlb_max{pagesize="2MB"} 6291456`,
+		`hugetlb_failcnt{pagesize="2MB"} 2`,
+	}
+	if !reflect.DeepEqual(metrics, expectedMetrics) {
+		t.Fatalf("expected metrics %q but got %q", expectedMetrics, metrics)
+	}
+}
+
+func TestEventHandlers(t *testing.T) {
+	// sample output of `runc events`, with the stats trimmed
+	input := []string{
+		`{"type":"stats","id":"test","data":{"pids":{"current":3}}}`,
+		`{"type":"oom","id":"test"}`,
+		`{"type":"intelrdt","id":"test"}`,
+	}
+	var (
+		stats   []uint64
+		ooms    []string
+		unknown []string
+	)
+	h := &EventHandlers{
+		Stats: func(id string, s *Stats) {
+			stats = append(stats, s.Pids.Current)
+		},
+		OOM: func(id string) {
+			ooms = append(ooms, id)
+		},
+		Unknown: func(e *Event) {
+			unknown = append(unknown, e.Type)
+		},
+	}
+	for _, in := range input {
+		var e Event
+		if err := json.Unmarshal([]byte(in), &e); err != nil {
+			t.Fatal(err)
+		}
+		switch e.Type {
+		case EventTypeStats:
+			if !e.IsStats() || e.IsOOM() {
+				t.Fatalf("expected %s to be a stats event", in)
+			}
+		case EventTypeOOM:
+			if !e.IsOOM() || e.IsStats() {
+				t.Fatalf("expected %s to be an oom event", in)
+			}
+		}
+		h.Handle(&e)
+	}
+	// a nil handler ignores the events of its type
+	h.Handle(&Event{Type: EventTypeError})
+
+	if !reflect.DeepEqual(stats, []uint64{3}) {
+		t.Fatalf("unexpected stats events %v", stats)
+	}
+	if !reflect.DeepEqual(ooms, []string{"test"}) {
+		t.Fatalf("unexpected oom events %v", ooms)
+	}
+	if !reflect.DeepEqual(unknown, []string{"intelrdt"}) {
+		t.Fatalf("unexpected unknown events %v", unknown)
+	}
+}
+
+func TestStatsPresent(t *testing.T) {
+	// a host without the io controller, where runc leaves out blkio
+	input := `{"type":"stats","id":"test","data":{"cpu":{"usage":{"total":0,"kernel":0,"user":0}},"memory":{"usage":{"limit":0,"failcnt":0}},"pids":{"current":0},"hugetlb":null}}`
+
+	var e Event
+	if err := json.Unmarshal([]byte(input), &e); err != nil {
+		t.Fatal(err)
+	}
+	if expected := StatsCpu | StatsMemory | StatsPids; e.Stats.Present != expected {
+		t.Fatalf("expected sections %b to be present, got %b", expected, e.Stats.Present)
+	}
+	if !e.Stats.Has(StatsPids) || e.Stats.Pids.Current != 0 {
+		t.Fatalf("expected a measured pids count of 0, got %+v", e.Stats.Pids)
+	}
+	for _, section := range []StatsSection{StatsBlkio, StatsHugetlb, StatsNetworkInterfaces} {
+		if e.Stats.Has(section) {
+			t.Fatalf("expected section %b to be absent", section)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrExitCodeNotWritten is returned by ReadExitCode when the exit code file
+// is empty, like when it has been created but not written yet
+var ErrExitCodeNotWritten = errors.New("exit code not written")
+
+// WriteExitCode writes the exit status of a container to the file at path,
+// as read by ReadExitCode. The file is replaced atomically, so a reader
+// never sees a partial exit code.
+func WriteExitCode(path string, status int) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strconv.Itoa(status) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ReadExitCode reads the exit status written to the file at path by a shim
+// once the container has exited. It returns ErrExitCodeNotWritten if the
+// file is empty.
+func ReadExitCode(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return -1, fmt.Errorf("%w: %s", ErrExitCodeNotWritten, path)
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil {
+		return -1, fmt.Errorf("invalid exit code in %s: %w", path, err)
+	}
+	return status, nil
+}
+
+// exitCodePollInterval is the interval of WaitExitCode when none is given
+const exitCodePollInterval = 100 * time.Millisecond
+
+// WaitExitCode polls the file at path every interval, or every 100ms if the
+// interval is not positive, until an exit status has been written to it, or
+// the context is done. The exit status is taken once it is terminated by a
+// newline, as written by WriteExitCode, or once the same content has been
+// read twice in a row for the shims writing it without a newline, so that
+// one being written is not read partially.
+func WaitExitCode(ctx context.Context, path string, interval time.Duration) (int, error) {
+	if interval <= 0 {
+		interval = exitCodePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last string
+	for {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return -1, err
+		}
+		if s := string(data); strings.HasSuffix(s, "\n") || (s != "" && s == last) {
+			return ReadExitCode(path)
+		}
+		last = string(data)
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
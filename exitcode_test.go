@@ -0,0 +1,93 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExitCodeRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exit")
+	for _, status := range []int{0, 1, 137} {
+		if err := WriteExitCode(path, status); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ReadExitCode(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != status {
+			t.Fatalf("expected exit code %d, got %d", status, actual)
+		}
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExitCode(path); !errors.Is(err, ErrExitCodeNotWritten) {
+		t.Fatalf("expected ErrExitCodeNotWritten for an empty file, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("garbage\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExitCode(path); err == nil {
+		t.Fatal("expected an error for an invalid exit code")
+	}
+}
+
+func TestWaitExitCode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exit")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		WriteExitCode(path, 137)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	status, err := WaitExitCode(ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 137 {
+		t.Fatalf("expected exit code 137, got %d", status)
+	}
+
+	// shims may write the exit code without a newline
+	path = filepath.Join(t.TempDir(), "exit")
+	if err := os.WriteFile(path, []byte("137"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	status, err = WaitExitCode(ctx, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 137 {
+		t.Fatalf("expected exit code 137 without a newline, got %d", status)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := WaitExitCode(ctx, filepath.Join(t.TempDir(), "exit"), 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+// SeccompFeatures holds the seccomp capabilities reported by the runtime
+// under `linux.seccomp` in the output of `runc features`.
+//
+// As in the features output, a nil list means "unknown" and is treated as
+// supporting every value.
+type SeccompFeatures struct {
+	features.Seccomp
+}
+
+// SeccompFeatures returns the seccomp capabilities of the runtime
+func (r *Runc) SeccompFeatures(context context.Context) (*SeccompFeatures, error) {
+	feat, err := r.cachedFeatures(context)
+	if err != nil {
+		return nil, err
+	}
+	var s SeccompFeatures
+	if feat.Linux != nil && feat.Linux.Seccomp != nil {
+		s.Seccomp = *feat.Linux.Seccomp
+	}
+	return &s, nil
+}
+
+// SupportsCgroupV2 returns whether the runtime supports cgroup v2, as
+// reported under `linux.cgroup.v2` by `runc features`. A runtime which does
+// not report it is assumed not to support it.
+func (r *Runc) SupportsCgroupV2(context context.Context) (bool, error) {
+	feat, err := r.cachedFeatures(context)
+	if err != nil {
+		return false, err
+	}
+	if feat.Linux == nil || feat.Linux.Cgroup == nil || feat.Linux.Cgroup.V2 == nil {
+		return false, nil
+	}
+	return *feat.Linux.Cgroup.V2, nil
+}
+
+// SupportsAction returns whether the runtime recognizes the seccomp action
+func (s *SeccompFeatures) SupportsAction(action specs.LinuxSeccompAction) bool {
+	return supported(s.Actions, string(action))
+}
+
+// SupportsOperator returns whether the runtime recognizes the seccomp operator
+func (s *SeccompFeatures) SupportsOperator(op specs.LinuxSeccompOperator) bool {
+	return supported(s.Operators, string(op))
+}
+
+// SupportsArch returns whether the runtime recognizes the seccomp architecture
+func (s *SeccompFeatures) SupportsArch(arch specs.Arch) bool {
+	return supported(s.Archs, string(arch))
+}
+
+// SupportsFlag returns whether the seccomp filter flag is supported by the
+// runtime, the kernel and libseccomp
+func (s *SeccompFeatures) SupportsFlag(flag specs.LinuxSeccompFlag) bool {
+	return supported(s.SupportedFlags, string(flag))
+}
+
+// Validate checks that every action, operator, architecture and flag used
+// by the seccomp profile is supported by the runtime
+func (s *SeccompFeatures) Validate(profile *specs.LinuxSeccomp) error {
+	if profile == nil {
+		return nil
+	}
+	if s.Enabled != nil && !*s.Enabled {
+		return fmt.Errorf("seccomp is not supported by the runtime")
+	}
+	if !s.SupportsAction(profile.DefaultAction) {
+		return fmt.Errorf("unsupported seccomp default action %q", profile.DefaultAction)
+	}
+	for _, arch := range profile.Architectures {
+		if !s.SupportsArch(arch) {
+			return fmt.Errorf("unsupported seccomp architecture %q", arch)
+		}
+	}
+	for _, flag := range profile.Flags {
+		if !s.SupportsFlag(flag) {
+			return fmt.Errorf("unsupported seccomp flag %q", flag)
+		}
+	}
+	for _, sc := range profile.Syscalls {
+		if !s.SupportsAction(sc.Action) {
+			return fmt.Errorf("unsupported seccomp action %q for syscalls %v", sc.Action, sc.Names)
+		}
+		for _, arg := range sc.Args {
+			if !s.SupportsOperator(arg.Op) {
+				return fmt.Errorf("unsupported seccomp operator %q for syscalls %v", arg.Op, sc.Names)
+			}
+		}
+	}
+	return nil
+}
+
+// supported returns whether v is in list, treating a nil list as unknown
+func supported(list []string, v string) bool {
+	if list == nil {
+		return true
+	}
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
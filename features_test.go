@@ -0,0 +1,180 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// testFeatures is a trimmed sample of the output of `runc features` (v1.1)
+const testFeatures = `{
+	"ociVersionMin": "1.0.0",
+	"ociVersionMax": "1.1.0",
+	"hooks": ["prestart", "createRuntime", "createContainer", "startContainer", "poststart", "poststop"],
+	"mountOptions": ["bind", "nodev", "noexec", "nosuid", "rbind", "ro", "rro", "rw"],
+	"linux": {
+		"namespaces": ["cgroup", "ipc", "mount", "network", "pid", "user", "uts"],
+		"cgroup": {"v1": true, "v2": true, "systemd": true, "systemdUser": true},
+		"seccomp": {
+			"enabled": true,
+			"actions": ["SCMP_ACT_ALLOW", "SCMP_ACT_ERRNO", "SCMP_ACT_KILL", "SCMP_ACT_LOG", "SCMP_ACT_TRAP"],
+			"operators": ["SCMP_CMP_EQ", "SCMP_CMP_NE", "SCMP_CMP_MASKED_EQ"],
+			"archs": ["SCMP_ARCH_X86", "SCMP_ARCH_X86_64"],
+			"knownFlags": ["SECCOMP_FILTER_FLAG_TSYNC", "SECCOMP_FILTER_FLAG_LOG", "SECCOMP_FILTER_FLAG_SPEC_ALLOW"],
+			"supportedFlags": ["SECCOMP_FILTER_FLAG_TSYNC", "SECCOMP_FILTER_FLAG_LOG"]
+		}
+	},
+	"annotations": {"org.opencontainers.runc.version": "1.1.0"}
+}`
+
+// newFeaturesRunc returns a Runc whose `features` subcommand prints feat
+func newFeaturesRunc(t *testing.T, feat string) *Runc {
+	return &Runc{
+		Command: newDummyRunc(t, "cat <<'EOF'\n"+feat+"\nEOF\n"),
+	}
+}
+
+func TestSeccompFeatures(t *testing.T) {
+	s, err := newFeaturesRunc(t, testFeatures).SeccompFeatures(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled == nil || !*s.Enabled {
+		t.Fatal("expected seccomp to be enabled")
+	}
+	if !s.SupportsAction(specs.ActErrno) || s.SupportsAction(specs.ActNotify) {
+		t.Fatalf("unexpected seccomp actions %v", s.Actions)
+	}
+	if !s.SupportsOperator(specs.OpMaskedEqual) || s.SupportsOperator(specs.OpGreaterThan) {
+		t.Fatalf("unexpected seccomp operators %v", s.Operators)
+	}
+	if !s.SupportsArch(specs.ArchX86_64) || s.SupportsArch(specs.ArchARM) {
+		t.Fatalf("unexpected seccomp archs %v", s.Archs)
+	}
+	if !s.SupportsFlag(specs.LinuxSeccompFlagLog) || s.SupportsFlag(specs.LinuxSeccompFlagSpecAllow) {
+		t.Fatalf("unexpected seccomp flags %v", s.SupportedFlags)
+	}
+
+	profile := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActErrno,
+		Architectures: []specs.Arch{specs.ArchX86_64},
+		Syscalls: []specs.LinuxSyscall{
+			{Names: []string{"read"}, Action: specs.ActAllow},
+		},
+	}
+	if err := s.Validate(profile); err != nil {
+		t.Fatalf("unexpected error validating profile: %s", err)
+	}
+	profile.Syscalls = append(profile.Syscalls, specs.LinuxSyscall{Names: []string{"connect"}, Action: specs.ActNotify})
+	if err := s.Validate(profile); err == nil {
+		t.Fatal("expected unsupported SCMP_ACT_NOTIFY to fail validation")
+	}
+
+	// a runtime not reporting seccomp features supports everything
+	unknown, err := newFeaturesRunc(t, `{"ociVersionMin": "1.0.0"}`).SeccompFeatures(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unknown.Validate(profile); err != nil {
+		t.Fatalf("unexpected error validating profile against unknown features: %s", err)
+	}
+}
+
+func TestFeaturesUnsupportedByVersion(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$arg" = "--version" ]; then
+		echo "runc version 1.0.0"
+		echo "spec: 1.0.2-dev"
+		exit 0
+	fi
+done
+echo "No help topic for 'features'" >&2
+exit 3
+`),
+	}
+	_, err := r.Features(context.Background())
+	var unsupported *ErrUnsupportedByVersion
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("expected ErrUnsupportedByVersion, got %v", err)
+	}
+	if unsupported.Feature != "features" || unsupported.Have != "1.0.0" || unsupported.Need != "1.1.0" {
+		t.Fatalf("unexpected error %+v", unsupported)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 3 {
+		t.Fatalf("expected the runc error to be wrapped, got %v", err)
+	}
+}
+
+func TestFlagsUnsupportedByVersion(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$arg" = "--version" ]; then
+		echo "runc version 1.0.0"
+		echo "spec: 1.0.2-dev"
+		exit 0
+	fi
+done
+echo "flag provided but not defined" >&2
+exit 1
+`),
+	}
+	err := r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{IgnorePaused: true})
+	var unsupported *ErrUnsupportedByVersion
+	if !errors.As(err, &unsupported) || unsupported.Feature != "--ignore-paused" || unsupported.Need != "1.1.0" {
+		t.Fatalf("expected ErrUnsupportedByVersion for --ignore-paused, got %v", err)
+	}
+	// the version check only applies to a flag that was passed
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{}, nil); errors.As(err, &unsupported) {
+		t.Fatalf("unexpected ErrUnsupportedByVersion without IgnorePaused: %v", err)
+	}
+
+	err = r.Checkpoint(context.Background(), "fake-id", &CheckpointOpts{
+		ImagePath:       t.TempDir(),
+		AllowOpenTCP:    true,
+		TCPSkipInFlight: true,
+	})
+	if !errors.As(err, &unsupported) || unsupported.Feature != "--tcp-skip-in-flight" || unsupported.Need != "1.2.0" {
+		t.Fatalf("expected ErrUnsupportedByVersion for --tcp-skip-in-flight, got %v", err)
+	}
+}
+
+func TestSupportsCgroupV2(t *testing.T) {
+	for _, tc := range []struct {
+		features string
+		expected bool
+	}{
+		{testFeatures, true},
+		{`{"linux": {"cgroup": {"v1": true, "v2": false}}}`, false},
+		{`{"ociVersionMin": "1.0.0"}`, false},
+	} {
+		v2, err := newFeaturesRunc(t, tc.features).SupportsCgroupV2(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v2 != tc.expected {
+			t.Errorf("expected cgroup v2 support %v for %s", tc.expected, tc.features)
+		}
+	}
+}
@@ -17,9 +17,12 @@
 package runc
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
 )
 
 // IO is the terminal IO interface
@@ -36,6 +39,15 @@ type StartCloser interface {
 	CloseAfterStart() error
 }
 
+// closeAfterStart closes the IO after its command has started, if it is a
+// StartCloser
+func closeAfterStart(io IO) error {
+	if c, ok := io.(StartCloser); ok {
+		return c.CloseAfterStart()
+	}
+	return nil
+}
+
 // IOOpt sets I/O creation options
 type IOOpt func(*IOOption)
 
@@ -44,6 +56,9 @@ type IOOption struct {
 	OpenStdin  bool
 	OpenStdout bool
 	OpenStderr bool
+	// StdinClose closes the write end of stdin once the process has
+	// started so that it reads EOF, for use with non-interactive processes
+	StdinClose bool
 }
 
 func defaultIOOption() *IOOption {
@@ -88,6 +103,8 @@ type pipeIO struct {
 	in  *pipe
 	out *pipe
 	err *pipe
+
+	stdinClose bool
 }
 
 func (i *pipeIO) Stdin() io.WriteCloser {
@@ -136,6 +153,9 @@ func (i *pipeIO) CloseAfterStart() error {
 			f.w.Close()
 		}
 	}
+	if i.stdinClose && i.in != nil {
+		i.in.w.Close()
+	}
 	return nil
 }
 
@@ -152,6 +172,68 @@ func (i *pipeIO) Set(cmd *exec.Cmd) {
 	}
 }
 
+// NewFifoIO opens the named fifos at the given paths as the stdio of the
+// process, for when the other end of the fifos belong to another process,
+// like a shim. Empty paths are left unset. The fifos are opened without
+// waiting for their other end to be opened, so that creating the container
+// doesn't hang; the fifos are expected to be read from and written to
+// by the time the process uses them. It is not implemented on Windows.
+//
+// As the other ends are not owned by the returned IO, its Stdin, Stdout and
+// Stderr are nil.
+func NewFifoIO(stdin, stdout, stderr string) (IO, error) {
+	return newFifoIO(stdin, stdout, stderr)
+}
+
+// fifoIO holds the ends of the fifos passed to the process
+type fifoIO struct {
+	in  *os.File
+	out *os.File
+	err *os.File
+}
+
+func (i *fifoIO) Stdin() io.WriteCloser {
+	return nil
+}
+
+func (i *fifoIO) Stdout() io.ReadCloser {
+	return nil
+}
+
+func (i *fifoIO) Stderr() io.ReadCloser {
+	return nil
+}
+
+// Close closes the fifos, which the process keeps open once started
+func (i *fifoIO) Close() error {
+	var err error
+	for _, f := range []*os.File{i.in, i.out, i.err} {
+		if f != nil {
+			if cerr := f.Close(); err == nil && !errors.Is(cerr, os.ErrClosed) {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (i *fifoIO) CloseAfterStart() error {
+	return i.Close()
+}
+
+// Set sets the io to the exec.Cmd
+func (i *fifoIO) Set(cmd *exec.Cmd) {
+	if i.in != nil {
+		cmd.Stdin = i.in
+	}
+	if i.out != nil {
+		cmd.Stdout = i.out
+	}
+	if i.err != nil {
+		cmd.Stderr = i.err
+	}
+}
+
 // NewSTDIO returns I/O setup for standard OS in/out/err usage
 func NewSTDIO() (IO, error) {
 	return &stdio{}, nil
@@ -224,3 +306,95 @@ func (n *nullIO) Set(c *exec.Cmd) {
 func (n *nullIO) CloseAfterStart() error {
 	return n.devNull.Close()
 }
+
+// bufferIO captures the output of the process in memory
+type bufferIO struct {
+	stdout, stderr bytes.Buffer
+}
+
+func (b *bufferIO) Close() error {
+	return nil
+}
+
+func (b *bufferIO) Stdin() io.WriteCloser {
+	return nil
+}
+
+func (b *bufferIO) Stdout() io.ReadCloser {
+	return nil
+}
+
+func (b *bufferIO) Stderr() io.ReadCloser {
+	return nil
+}
+
+func (b *bufferIO) Set(c *exec.Cmd) {
+	c.Stdout = &b.stdout
+	c.Stderr = &b.stderr
+}
+
+// DefaultMaxLineBytes is the longest line buffered by a LineWriter created
+// with a non-positive limit
+const DefaultMaxLineBytes = 64 * 1024
+
+// LineWriter writes to the underlying writer line by line: every write to
+// the underlying writer is a complete line ending with '\n', the trailing
+// partial line being buffered until it is completed. Lines longer than the
+// limit are written in chunks of the limit to bound the buffering.
+//
+// It allows relaying the output of a process, like copied from the Stdout of
+// an IO, to parsers expecting complete lines.
+type LineWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	limit int
+	buf   []byte
+}
+
+// NewLineWriter returns a LineWriter writing to w lines of at most limit
+// bytes, or DefaultMaxLineBytes if limit is not positive
+func NewLineWriter(w io.Writer, limit int) *LineWriter {
+	if limit <= 0 {
+		limit = DefaultMaxLineBytes
+	}
+	return &LineWriter{w: w, limit: limit}
+}
+
+// Write buffers p and writes the lines it completes
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		n := i + 1
+		if i < 0 || n > l.limit {
+			if len(l.buf) < l.limit {
+				return len(p), nil
+			}
+			// the line is too long, write as much of it as allowed
+			n = l.limit
+		}
+		if _, err := l.w.Write(l.buf[:n]); err != nil {
+			return len(p), err
+		}
+		l.buf = l.buf[n:]
+	}
+}
+
+// Flush writes the buffered partial line, if any
+func (l *LineWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) == 0 {
+		return nil
+	}
+	_, err := l.w.Write(l.buf)
+	l.buf = nil
+	return err
+}
+
+// Close flushes the partial line, as the output has ended
+func (l *LineWriter) Close() error {
+	return l.Flush()
+}
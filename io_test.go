@@ -0,0 +1,59 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recordingWriter records each write it receives
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestLineWriter(t *testing.T) {
+	var rec recordingWriter
+	w := NewLineWriter(&rec, 8)
+	for _, chunk := range []string{
+		"hel", "lo\nwor", "ld\n",
+		"a\nb\nc",
+		"\n",
+		"0123456789abc\n",
+		"partial",
+	} {
+		if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("unexpected write of %q: %d, %v", chunk, n, err)
+		}
+	}
+	expected := []string{"hello\n", "world\n", "a\n", "b\n", "c\n", "01234567", "89abc\n"}
+	if !reflect.DeepEqual(rec.writes, expected) {
+		t.Fatalf("expected writes %q, got %q", expected, rec.writes)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, "partial")
+	if !reflect.DeepEqual(rec.writes, expected) {
+		t.Fatalf("expected the partial line on close, got %q", rec.writes)
+	}
+}
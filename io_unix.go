@@ -20,6 +20,7 @@ package runc
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/sirupsen/logrus"
@@ -90,8 +91,63 @@ func newPipeIO(uid, gid int, opts ...IOOpt) (i IO, err error) {
 		}
 	}
 	return &pipeIO{
-		in:  stdin,
-		out: stdout,
-		err: stderr,
+		in:         stdin,
+		out:        stdout,
+		err:        stderr,
+		stdinClose: option.StdinClose,
 	}, nil
 }
+
+// newFifoIO opens the fifos to be used as the stdio of the process
+func newFifoIO(stdin, stdout, stderr string) (_ IO, err error) {
+	i := &fifoIO{}
+	defer func() {
+		if err != nil {
+			i.Close()
+		}
+	}()
+	if stdin != "" {
+		if i.in, err = openFifo(stdin, os.O_RDONLY); err != nil {
+			return nil, err
+		}
+	}
+	if stdout != "" {
+		if i.out, err = openFifo(stdout, os.O_WRONLY); err != nil {
+			return nil, err
+		}
+	}
+	if stderr != "" {
+		if i.err, err = openFifo(stderr, os.O_WRONLY); err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// openFifo opens an end of the fifo without blocking until the other end is
+// opened. The read end is opened with O_NONBLOCK, which is then cleared for
+// the process to block on reads. The write end is opened read-write, as
+// opening it write-only without a reader fails with ENXIO.
+func openFifo(path string, flag int) (*os.File, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is not a fifo", path)
+	}
+	if flag == os.O_WRONLY {
+		flag = os.O_RDWR
+	} else {
+		flag |= unix.O_NONBLOCK
+	}
+	fd, err := unix.Open(path, flag|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	if err := unix.SetNonblock(fd, false); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,88 @@
+//go:build !windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFifoIO(t *testing.T) {
+	dir := t.TempDir()
+	stdin, stdout := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout")
+	for _, path := range []string{stdin, stdout} {
+		if err := unix.Mkfifo(path, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// neither fifo has its other end opened yet
+	opened := make(chan IO, 1)
+	go func() {
+		i, err := NewFifoIO(stdin, stdout, "")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- i
+	}()
+	var i IO
+	select {
+	case i = <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out opening the fifos")
+	}
+	if i == nil {
+		t.FailNow()
+	}
+	defer i.Close()
+
+	w, err := os.OpenFile(stdin, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	rd, err := os.OpenFile(stdout, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	r := &Runc{
+		Command: newDummyRunc(t, "cat\n"),
+	}
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{IO: i}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected the stdin fifo to be copied to the stdout fifo, got %q", data)
+	}
+}
@@ -23,3 +23,7 @@ import "errors"
 func newPipeIO(uid, gid int, opts ...IOOpt) (i IO, err error) {
 	return nil, errors.New("not implemented on Windows")
 }
+
+func newFifoIO(stdin, stdout, stderr string) (IO, error) {
+	return nil, errors.New("not implemented on Windows")
+}
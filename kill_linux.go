@@ -0,0 +1,59 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// pidfd refers to a process, unlike its pid which may be reused once it
+// has exited
+type pidfd int
+
+func openPidfd(pid int) (pidfd, error) {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("pidfd_open", err)
+	}
+	return pidfd(fd), nil
+}
+
+// signal sends the signal to the process, failing with ESRCH if it has
+// exited
+func (fd pidfd) signal(sig syscall.Signal) error {
+	return os.NewSyscallError("pidfd_send_signal", unix.PidfdSendSignal(int(fd), sig, nil, 0))
+}
+
+// exited returns whether the process has exited, without blocking
+func (fd pidfd) exited() (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	if err == unix.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, os.NewSyscallError("poll", err)
+	}
+	return n > 0, nil
+}
+
+func (fd pidfd) Close() error {
+	return unix.Close(int(fd))
+}
@@ -0,0 +1,40 @@
+//go:build !linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import "syscall"
+
+// pidfd refers to a process, which is not supported outside of linux
+type pidfd int
+
+func openPidfd(pid int) (pidfd, error) {
+	return -1, syscall.ENOSYS
+}
+
+func (fd pidfd) signal(sig syscall.Signal) error {
+	return syscall.ENOSYS
+}
+
+func (fd pidfd) exited() (bool, error) {
+	return false, syscall.ENOSYS
+}
+
+func (fd pidfd) Close() error {
+	return nil
+}
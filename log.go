@@ -0,0 +1,143 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// logTailInterval is how often TailLog checks the log for new entries
+const logTailInterval = 100 * time.Millisecond
+
+// LogEntry is an entry of the runc log written with the JSON format
+type LogEntry struct {
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+	Time  time.Time `json:"time"`
+}
+
+// logOffset returns the size of the JSON log of r, which new entries are
+// written after, or -1 if r doesn't write a JSON log
+func (r *Runc) logOffset() int64 {
+	if r.Log == "" || r.LogFormat != JSON {
+		return -1
+	}
+	fi, err := os.Stat(r.Log)
+	if err != nil {
+		// runc creates the log
+		return 0
+	}
+	return fi.Size()
+}
+
+// lastLogError returns the message of the last error entry written to the
+// JSON log of r after offset, or "" if there is none
+func (r *Runc) lastLogError(offset int64) string {
+	f, err := os.Open(r.Log)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return ""
+	}
+	var msg string
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var e LogEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Level == "error" || e.Level == "fatal" {
+			msg = e.Msg
+		}
+	}
+	return msg
+}
+
+// TailLog follows the JSON log of the container and sends the entries
+// appended to it on the returned channel, until the context is done and the
+// channel is closed. The log is r.Log or, when unset, the log.json file in
+// the bundle of the container, where containerd writes it.
+//
+// Lines which are not JSON entries, as written with another LogFormat, are
+// skipped.
+func (r *Runc) TailLog(context context.Context, id string) (<-chan LogEntry, error) {
+	path := r.Log
+	if path == "" {
+		c, err := r.State(context, id)
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(c.Bundle, "log.json")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c := make(chan LogEntry)
+	go func() {
+		defer close(c)
+		defer f.Close()
+		var (
+			rd      = bufio.NewReader(f)
+			partial []byte
+			ticker  = time.NewTicker(logTailInterval)
+		)
+		defer ticker.Stop()
+		for {
+			line, err := rd.ReadBytes('\n')
+			partial = append(partial, line...)
+			if err == io.EOF {
+				// wait for the rest of the line to be written
+				select {
+				case <-context.Done():
+					return
+				case <-ticker.C:
+				}
+				continue
+			}
+			if err != nil {
+				return
+			}
+			var e LogEntry
+			err = json.Unmarshal(bytes.TrimSpace(partial), &e)
+			partial = partial[:0]
+			if err != nil {
+				continue
+			}
+			select {
+			case c <- e:
+			case <-context.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
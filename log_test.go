@@ -0,0 +1,175 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestRuncLogError(t *testing.T) {
+	ctx := context.Background()
+	log := filepath.Join(t.TempDir(), "log.json")
+	// an error logged by a previous command is not reported again
+	if err := os.WriteFile(log, []byte(`{"level":"error","msg":"stale error","time":"2023-01-02T03:04:05Z"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--log" ]; then
+		log=$arg
+	fi
+	prev=$arg
+done
+echo '{"level":"info","msg":"starting","time":"2023-01-02T03:04:06Z"}' >> "$log"
+echo '{"level":"error","msg":"'"$5"' failed: container not running","time":"2023-01-02T03:04:07Z"}' >> "$log"
+echo '{"level":"debug","msg":"exiting","time":"2023-01-02T03:04:08Z"}' >> "$log"
+exit 1
+`),
+		Log:       log,
+		LogFormat: JSON,
+	}
+	for _, tc := range []struct {
+		command string
+		run     func() error
+	}{
+		{"create", func() error { return r.Create(ctx, "fake-id", newTestBundle(t, nil), nil) }},
+		{"start", func() error { return r.Start(ctx, "fake-id") }},
+		{"exec", func() error { return r.Exec(ctx, "fake-id", specs.Process{}, nil) }},
+		{"delete", func() error { return r.Delete(ctx, "fake-id", nil) }},
+		{"kill", func() error { return r.Kill(ctx, "fake-id", int(syscall.SIGKILL), nil) }},
+	} {
+		err := tc.run()
+		if err == nil {
+			t.Fatalf("%s: expected an error", tc.command)
+		}
+		if msg := tc.command + " failed: container not running"; !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: expected %q in the error, got %s", tc.command, msg, err)
+		}
+		if strings.Contains(err.Error(), "stale error") {
+			t.Errorf("%s: unexpected error logged before the command: %s", tc.command, err)
+		}
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || exitErr.Status != 1 {
+			t.Errorf("%s: expected the exit status to be wrapped, got %v", tc.command, err)
+		}
+	}
+}
+
+func TestRuncLogErrorConcurrent(t *testing.T) {
+	ctx := context.Background()
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--log" ]; then
+		log=$arg
+	fi
+	prev=$arg
+done
+if [ "$5" = "start" ]; then
+	sleep 0.1
+fi
+echo '{"level":"error","msg":"'"$5"' failed","time":"2023-01-02T03:04:07Z"}' >> "$log"
+sleep 0.3
+exit 1
+`),
+		Log:       filepath.Join(t.TempDir(), "log.json"),
+		LogFormat: JSON,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Start(ctx, "fake-id")
+	}()
+	err := r.Kill(ctx, "fake-id", int(syscall.SIGKILL), nil)
+	// the last error logged is the one of start, which isn't told apart
+	if err == nil || strings.Contains(err.Error(), "failed") {
+		t.Fatalf("expected the error of kill without a log entry, got %v", err)
+	}
+	if err := <-errs; err == nil || strings.Contains(err.Error(), "failed") {
+		t.Fatalf("expected the error of start without a log entry, got %v", err)
+	}
+	// once alone, the command gets its entry again
+	if err := r.Kill(ctx, "fake-id", int(syscall.SIGKILL), nil); err == nil || !strings.Contains(err.Error(), "kill failed") {
+		t.Fatalf("expected the logged error of kill, got %v", err)
+	}
+}
+
+func TestRuncTailLog(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log.json")
+	if err := os.WriteFile(log, []byte(`{"level":"info","msg":"before tailing","time":"2023-01-02T03:04:05Z"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runc{
+		Log:       log,
+		LogFormat: JSON,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	entries, err := r.TailLog(ctx, "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// the second entry is written in two parts
+	for _, data := range []string{
+		`{"level":"info","msg":"first","time":"2023-01-02T03:04:06Z"}` + "\nnot json\n",
+		`{"level":"error","msg":"sec`,
+		`ond","time":"2023-01-02T03:04:07Z"}` + "\n",
+	} {
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, expected := range []LogEntry{
+		{Level: "info", Msg: "first", Time: time.Date(2023, 1, 2, 3, 4, 6, 0, time.UTC)},
+		{Level: "error", Msg: "second", Time: time.Date(2023, 1, 2, 3, 4, 7, 0, time.UTC)},
+	} {
+		select {
+		case e := <-entries:
+			if e.Level != expected.Level || e.Msg != expected.Msg || !e.Time.Equal(expected.Time) {
+				t.Fatalf("expected entry %+v, got %+v", expected, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for entry %q", expected.Msg)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-entries:
+		if ok {
+			t.Fatal("expected no entry after the appended ones")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the channel to be closed")
+	}
+}
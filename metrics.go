@@ -0,0 +1,139 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric is a single named sample flattened from Stats
+type Metric struct {
+	Name   string
+	Labels map[string]string
+	Value  uint64
+}
+
+// String returns the metric as a line of the Prometheus text format
+func (m Metric) String() string {
+	if len(m.Labels) == 0 {
+		return fmt.Sprintf("%s %d", m.Name, m.Value)
+	}
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, len(keys))
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, m.Labels[k]))
+	}
+	return fmt.Sprintf("%s{%s} %d", m.Name, strings.Join(labels, ","), m.Value)
+}
+
+// Metrics flattens the cpu, memory, hugetlb, pids and blkio statistics into
+// named samples, in a stable order
+func (s *Stats) Metrics() []Metric {
+	var out []Metric
+	add := func(name string, value uint64, labels ...string) {
+		m := Metric{Name: name, Value: value}
+		if len(labels) > 0 {
+			m.Labels = make(map[string]string, len(labels)/2)
+			for i := 0; i+1 < len(labels); i += 2 {
+				m.Labels[labels[i]] = labels[i+1]
+			}
+		}
+		out = append(out, m)
+	}
+
+	add("cpu_usage_total", s.Cpu.Usage.Total)
+	add("cpu_usage_kernel", s.Cpu.Usage.Kernel)
+	add("cpu_usage_user", s.Cpu.Usage.User)
+	for i, v := range s.Cpu.Usage.Percpu {
+		add("cpu_usage_percpu", v, "cpu", strconv.Itoa(i))
+	}
+	add("cpu_throttling_periods", s.Cpu.Throttling.Periods)
+	add("cpu_throttling_throttled_periods", s.Cpu.Throttling.ThrottledPeriods)
+	add("cpu_throttling_throttled_time", s.Cpu.Throttling.ThrottledTime)
+
+	add("memory_cache", s.Memory.Cache)
+	for _, e := range []struct {
+		name  string
+		entry MemoryEntry
+	}{
+		{"usage", s.Memory.Usage},
+		{"swap", s.Memory.Swap},
+		{"kernel", s.Memory.Kernel},
+		{"kernel_tcp", s.Memory.KernelTCP},
+	} {
+		add("memory_"+e.name+"_usage", e.entry.Usage)
+		add("memory_"+e.name+"_limit", e.entry.Limit)
+		add("memory_"+e.name+"_max", e.entry.Max)
+		add("memory_"+e.name+"_failcnt", e.entry.Failcnt)
+	}
+	raw := make([]string, 0, len(s.Memory.Raw))
+	for k := range s.Memory.Raw {
+		raw = append(raw, k)
+	}
+	sort.Strings(raw)
+	for _, k := range raw {
+		add("memory_raw", s.Memory.Raw[k], "key", k)
+	}
+
+	pageSizes := make([]string, 0, len(s.Hugetlb))
+	for k := range s.Hugetlb {
+		pageSizes = append(pageSizes, k)
+	}
+	sort.Strings(pageSizes)
+	for _, k := range pageSizes {
+		h := s.Hugetlb[k]
+		add("hugetlb_usage", h.Usage, "pagesize", k)
+		add("hugetlb_max", h.Max, "pagesize", k)
+		add("hugetlb_failcnt", h.Failcnt, "pagesize", k)
+	}
+
+	add("pids_current", s.Pids.Current)
+	add("pids_limit", s.Pids.Limit)
+
+	for _, b := range []struct {
+		name    string
+		entries []BlkioEntry
+	}{
+		{"io_service_bytes_recursive", s.Blkio.IoServiceBytesRecursive},
+		{"io_serviced_recursive", s.Blkio.IoServicedRecursive},
+		{"io_queued_recursive", s.Blkio.IoQueuedRecursive},
+		{"io_service_time_recursive", s.Blkio.IoServiceTimeRecursive},
+		{"io_wait_time_recursive", s.Blkio.IoWaitTimeRecursive},
+		{"io_merged_recursive", s.Blkio.IoMergedRecursive},
+		{"io_time_recursive", s.Blkio.IoTimeRecursive},
+		{"sectors_recursive", s.Blkio.SectorsRecursive},
+	} {
+		for _, e := range b.entries {
+			labels := []string{
+				"major", strconv.FormatUint(e.Major, 10),
+				"minor", strconv.FormatUint(e.Minor, 10),
+			}
+			if e.Op != "" {
+				labels = append(labels, "op", e.Op)
+			}
+			add("blkio_"+b.name, e.Value, labels...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStatsMetrics(t *testing.T) {
+	input := `{
+	"cpu": {
+		"usage": {"total": 1000, "percpu": [600, 400], "kernel": 300, "user": 700},
+		"throttling": {"periods": 10, "throttledPeriods": 2, "throttledTime": 50}
+	},
+	"memory": {
+		"cache": 4096,
+		"usage": {"limit": 1048576, "usage": 8192, "max": 16384, "failcnt": 1},
+		"raw": {"rss": 2048, "active_file": 1024}
+	},
+	"pids": {"current": 3, "limit": 100},
+	"blkio": {
+		"ioServiceBytesRecursive": [
+			{"major": 8, "minor": 0, "op": "Read", "value": 512},
+			{"major": 8, "minor": 0, "op": "Write", "value": 256}
+		]
+	}
+}`
+	expected := `cpu_usage_total 1000
+cpu_usage_kernel 300
+cpu_usage_user 700
+cpu_usage_percpu{cpu="0"} 600
+cpu_usage_percpu{cpu="1"} 400
+cpu_throttling_periods 10
+cpu_throttling_throttled_periods 2
+cpu_throttling_throttled_time 50
+memory_cache 4096
+memory_usage_usage 8192
+memory_usage_limit 1048576
+memory_usage_max 16384
+memory_usage_failcnt 1
+memory_swap_usage 0
+memory_swap_limit 0
+memory_swap_max 0
+memory_swap_failcnt 0
+memory_kernel_usage 0
+memory_kernel_limit 0
+memory_kernel_max 0
+memory_kernel_failcnt 0
+memory_kernel_tcp_usage 0
+memory_kernel_tcp_limit 0
+memory_kernel_tcp_max 0
+memory_kernel_tcp_failcnt 0
+memory_raw{key="active_file"} 1024
+memory_raw{key="rss"} 2048
+pids_current 3
+pids_limit 100
+blkio_io_service_bytes_recursive{major="8",minor="0",op="Read"} 512
+blkio_io_service_bytes_recursive{major="8",minor="0",op="Write"} 256`
+
+	var s Stats
+	if err := json.Unmarshal([]byte(input), &s); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, m := range s.Metrics() {
+		lines = append(lines, m.String())
+	}
+	if actual := strings.Join(lines, "\n"); actual != expected {
+		t.Fatalf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
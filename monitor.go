@@ -19,18 +19,42 @@ package runc
 import (
 	"os/exec"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 )
 
 // Monitor is the default ProcessMonitor for handling runc process exit
+//
+// Deprecated: assigning Monitor races with the commands being run
+// concurrently, use SetMonitor and GetMonitor instead.
 var Monitor ProcessMonitor = &defaultMonitor{}
 
+var monitorMu sync.RWMutex
+
+// SetMonitor replaces the ProcessMonitor used to start and wait for the runc
+// commands. It is safe to call while commands are running: each command is
+// waited for by the monitor which started it.
+func SetMonitor(m ProcessMonitor) {
+	monitorMu.Lock()
+	Monitor = m
+	monitorMu.Unlock()
+}
+
+// GetMonitor returns the ProcessMonitor used to start the runc commands
+func GetMonitor() ProcessMonitor {
+	monitorMu.RLock()
+	defer monitorMu.RUnlock()
+	return Monitor
+}
+
 // Exit holds the exit information from a process
 type Exit struct {
 	Timestamp time.Time
 	Pid       int
 	Status    int
+	// Rusage is the resource usage of the exited process, if available
+	Rusage *syscall.Rusage
 }
 
 // ProcessMonitor is an interface for process monitoring.
@@ -56,20 +80,7 @@ func (m *defaultMonitor) Start(c *exec.Cmd) (chan Exit, error) {
 	}
 	ec := make(chan Exit, 1)
 	go func() {
-		var status int
-		if err := c.Wait(); err != nil {
-			status = 255
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					status = ws.ExitStatus()
-				}
-			}
-		}
-		ec <- Exit{
-			Timestamp: time.Now(),
-			Pid:       c.Process.Pid,
-			Status:    status,
-		}
+		ec <- newExit(c, c.Wait())
 		close(ec)
 	}()
 	return ec, nil
@@ -90,20 +101,7 @@ func (m *defaultMonitor) StartLocked(c *exec.Cmd) (chan Exit, error) {
 			return
 		}
 		close(started)
-		var status int
-		if err := c.Wait(); err != nil {
-			status = 255
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					status = ws.ExitStatus()
-				}
-			}
-		}
-		ec <- Exit{
-			Timestamp: time.Now(),
-			Pid:       c.Process.Pid,
-			Status:    status,
-		}
+		ec <- newExit(c, c.Wait())
 		close(ec)
 	}()
 	if err := <-started; err != nil {
@@ -112,6 +110,30 @@ func (m *defaultMonitor) StartLocked(c *exec.Cmd) (chan Exit, error) {
 	return ec, nil
 }
 
+// newExit returns the Exit of the command c, which returned err from Wait
+func newExit(c *exec.Cmd, err error) Exit {
+	var status int
+	if err != nil {
+		status = 255
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				status = ws.ExitStatus()
+			}
+		}
+	}
+	e := Exit{
+		Timestamp: time.Now(),
+		Pid:       c.Process.Pid,
+		Status:    status,
+	}
+	if c.ProcessState != nil {
+		if ru, ok := c.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			e.Rusage = ru
+		}
+	}
+	return e
+}
+
 func (m *defaultMonitor) Wait(c *exec.Cmd, ec chan Exit) (int, error) {
 	e := <-ec
 	return e.Status, nil
@@ -0,0 +1,48 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestMonitorRusage(t *testing.T) {
+	for name, start := range map[string]func(*exec.Cmd) (chan Exit, error){
+		"Start":       Monitor.Start,
+		"StartLocked": Monitor.StartLocked,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// busy loop for a bit so that some cpu time is accounted
+			cmd := exec.Command("/bin/sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done")
+			ec, err := start(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := <-ec
+			if e.Status != 0 {
+				t.Fatalf("expected exit status 0, got %d", e.Status)
+			}
+			if e.Rusage == nil {
+				t.Fatal("expected rusage to be populated")
+			}
+			if e.Rusage.Maxrss == 0 {
+				t.Fatal("expected a non-zero max rss")
+			}
+		})
+	}
+}
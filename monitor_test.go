@@ -0,0 +1,80 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingMonitor counts the commands it started and waited for
+type countingMonitor struct {
+	defaultMonitor
+	started, waited atomic.Int64
+}
+
+func (m *countingMonitor) Start(c *exec.Cmd) (chan Exit, error) {
+	ec, err := m.defaultMonitor.Start(c)
+	if err == nil {
+		m.started.Add(1)
+	}
+	return ec, err
+}
+
+func (m *countingMonitor) Wait(c *exec.Cmd, ec chan Exit) (int, error) {
+	m.waited.Add(1)
+	return m.defaultMonitor.Wait(c, ec)
+}
+
+func TestSetMonitor(t *testing.T) {
+	orig := GetMonitor()
+	defer SetMonitor(orig)
+
+	r := &Runc{
+		Command: newDummyRunc(t, "exit 0\n"),
+	}
+	monitors := []*countingMonitor{{}, {}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := r.Start(context.Background(), "fake-id"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		SetMonitor(monitors[i%len(monitors)])
+	}
+	wg.Wait()
+
+	for i, m := range monitors {
+		if started, waited := m.started.Load(), m.waited.Load(); started != waited {
+			t.Errorf("monitor %d waited for %d commands but started %d", i, waited, started)
+		}
+	}
+	if GetMonitor() != monitors[1] {
+		t.Fatal("expected GetMonitor to return the last monitor set")
+	}
+}
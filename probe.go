@@ -0,0 +1,104 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+// Opt configures the Runc returned by New
+type Opt func(*Runc)
+
+// WithCommand sets the runc binary to run
+func WithCommand(command string) Opt {
+	return func(r *Runc) {
+		r.Command = command
+	}
+}
+
+// WithRoot sets the root directory of the container states
+func WithRoot(root string) Opt {
+	return func(r *Runc) {
+		r.Root = root
+	}
+}
+
+// WithProbe makes New run the binary once to read its version and features.
+// They are cached for the lifetime of the Runc, so the checks relying on
+// them don't run the binary again.
+func WithProbe() Opt {
+	return func(r *Runc) {
+		r.probe = true
+	}
+}
+
+// ProbeError is returned by New when the runc binary could not be probed,
+// like when it is missing
+type ProbeError struct {
+	Command string
+	Err     error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("probing %s: %v", e.Command, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// New returns a Runc configured by the options. Without WithProbe, it is
+// the same as a Runc literal and does not run the binary.
+func New(ctx context.Context, opts ...Opt) (*Runc, error) {
+	r := &Runc{}
+	for _, o := range opts {
+		o(r)
+	}
+	if !r.probe {
+		return r, nil
+	}
+	command := r.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+	if _, err := r.cachedVersion(ctx); err != nil {
+		return nil, &ProbeError{Command: command, Err: err}
+	}
+	feat, err := r.Features(ctx)
+	var unsupported *ErrUnsupportedByVersion
+	switch {
+	case errors.As(err, &unsupported):
+		// runc before 1.1 doesn't report its features
+	case err != nil:
+		return nil, &ProbeError{Command: command, Err: err}
+	}
+	r.features = feat
+	return r, nil
+}
+
+// cachedFeatures returns the features probed by New, or runs the binary to
+// get them when it has not been probed
+func (r *Runc) cachedFeatures(context context.Context) (*features.Features, error) {
+	if r.probe && r.features != nil {
+		return r.features, nil
+	}
+	return r.Features(context)
+}
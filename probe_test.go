@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewProbeMissingBinary(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "runc")
+	if _, err := New(context.Background(), WithCommand(missing)); err != nil {
+		t.Fatalf("expected New not to run the binary without probing, got %v", err)
+	}
+	_, err := New(context.Background(), WithCommand(missing), WithProbe())
+	var probeErr *ProbeError
+	if !errors.As(err, &probeErr) || probeErr.Command != missing {
+		t.Fatalf("expected a ProbeError for %s, got %v", missing, err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the missing binary error to be wrapped, got %v", err)
+	}
+}
+
+func TestNewProbe(t *testing.T) {
+	command := newDummyRunc(t, `
+case "$1" in
+--version)
+	printf 'runc version 1.1.12\ncommit: v1.1.12-0-g51d5e946\nspec: 1.0.2-dev\n'
+	;;
+features)
+	cat <<'EOF'
+`+testFeatures+`
+EOF
+	;;
+*)
+	exit 1
+	;;
+esac
+`)
+	r, err := New(context.Background(), WithCommand(command), WithProbe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the checks rely on the probe rather than running the binary again
+	if err := os.WriteFile(command, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.cachedVersion(context.Background())
+	if err != nil || v.Runc != "1.1.12" {
+		t.Fatalf("expected the probed version, got %+v, %v", v, err)
+	}
+	if _, err := r.SeccompFeatures(context.Background()); err != nil {
+		t.Fatalf("expected the probed features, got %v", err)
+	}
+}
+
+func TestCachedVersionError(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "printf 'runc version 1.1.12\\nspec: 1.0.2-dev\\n'\n"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.cachedVersion(ctx); err == nil {
+		t.Fatal("expected an error with a cancelled context")
+	}
+	// the error is not cached
+	v, err := r.cachedVersion(context.Background())
+	if err != nil || v.Runc != "1.1.12" {
+		t.Fatalf("expected the version once the context is valid, got %+v, %v", v, err)
+	}
+	if err := os.WriteFile(r.Command, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.cachedVersion(context.Background()); err != nil || v.Runc != "1.1.12" {
+		t.Fatalf("expected the cached version, got %+v, %v", v, err)
+	}
+}
@@ -17,6 +17,7 @@
 package runc
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -26,8 +27,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -55,6 +59,19 @@ const (
 	Text Format = "text"
 )
 
+// Flavor identifies the OCI runtime implementation run as Command, as
+// runc-compatible runtimes accept slightly different global flags
+type Flavor string
+
+const (
+	// FlavorRunc is runc, used when no Flavor is set
+	FlavorRunc Flavor = "runc"
+	// FlavorRunsc is gVisor's runsc
+	FlavorRunsc Flavor = "runsc"
+	// FlavorKata is the kata-runtime of Kata Containers
+	FlavorKata Flavor = "kata"
+)
+
 // DefaultCommand is the default command for Runc
 var DefaultCommand = "runc"
 
@@ -79,7 +96,14 @@ type Runc struct {
 	// runtime.LockOSThread. Callers should ensure they retain at least one
 	// unlocked thread.
 	PdeathSignal syscall.Signal // using syscall.Signal to allow compilation on non-unix (unix.Syscall is an alias for syscall.Signal)
-	Setpgid      bool
+	// Setpgid puts each runc command in a new process group, so that the
+	// signals sent to the process group of the caller, like the SIGINT of
+	// ^C in a terminal, don't reach runc. Leave it unset to keep runc in the
+	// foreground process group of the terminal when debugging.
+	//
+	// Both are independent: PdeathSignal is delivered when the thread that
+	// started runc exits whether or not runc is in its own process group.
+	Setpgid bool
 
 	// Criu sets the path to the criu binary used for checkpoint and restore.
 	//
@@ -89,38 +113,258 @@ type Runc struct {
 	// in $PATH.
 	Criu          string
 	SystemdCgroup bool
-	Rootless      *bool // nil stands for "auto"
-	ExtraArgs     []string
+	// SystemdCgroupAuto enables SystemdCgroup when the host is booted with
+	// systemd. Detection happens once and is cached for the lifetime of the
+	// Runc.
+	SystemdCgroupAuto bool
+	Rootless          *bool // nil stands for "auto"
+	ExtraArgs         []string
+	// Flavor adjusts the global flags for runtimes other than runc.
+	Flavor Flavor
+	// Timeout, if non-zero, kills every runc command that runs for longer
+	// than the timeout, as if it was run with a context deadline, and its
+	// error then wraps context.DeadlineExceeded. When the context passed to
+	// a method has an earlier deadline, that one wins.
+	// The commands running as long as a container process, the foreground
+	// run, exec and restore, and the events stream are left to their
+	// context.
+	Timeout time.Duration
+	// RetryPolicy, if set, retries the idempotent commands which fail with a
+	// transient error.
+	RetryPolicy *RetryPolicy
+	// Metrics, if set, is called with the wall-clock duration of each runc
+	// command once it has exited, along with its subcommand and error.
+	Metrics func(cmd string, d time.Duration, err error)
+	// DefaultCreateOpts and DefaultExecOpts hold the options applied to every
+	// create, run and exec. The options set for a call take precedence, but
+	// booleans enabled by default can't be disabled per call.
+	DefaultCreateOpts *CreateOpts
+	DefaultExecOpts   *ExecOpts
+	// MaxConcurrent, if non-zero, bounds how many runc commands run at once.
+	// The commands beyond the limit wait for a running one to exit, or for
+	// their context to be done, before being started. Like for Timeout, the
+	// commands running as long as a container process or an events stream
+	// are not bounded.
+	MaxConcurrent int
+	// JSONMarshal and JSONUnmarshal, if set, replace encoding/json to encode
+	// the process and resources passed to runc, and to decode the state,
+	// list, events, stats, processes and features output by runc.
+	JSONMarshal   func(v interface{}) ([]byte, error)
+	JSONUnmarshal func(data []byte, v interface{}) error
+	// MaxErrorOutput, if non-zero, caps the runc output attached to errors
+	// to its last MaxErrorOutput bytes, where runc reports the failure. It
+	// keeps the errors of commands run with Debug from carrying megabytes of
+	// debug logs.
+	MaxErrorOutput int
+	// StatusPollInterval is the time between the state checks of Stop and
+	// WaitForStatus. If zero, the state is checked every 100ms.
+	StatusPollInterval time.Duration
+	// RuntimeClassAnnotation, if set, is the annotation of the bundle spec
+	// read by create and run to select a transform in RuntimeClasses. The
+	// transform receives the arguments of the subcommand, without the
+	// container id, and returns the arguments to run it with.
+	RuntimeClassAnnotation string
+	RuntimeClasses         map[string]func(args []string) []string
+
+	// probe is set by WithProbe, features holds the features probed by New
+	probe    bool
+	features *features.Features
+
+	// st is allocated on first use, see state
+	st *runcState
+}
+
+// runcState holds what a Runc caches and tracks. It is kept behind a
+// pointer so that a Runc can be copied, the copies made after its first use
+// sharing it.
+type runcState struct {
+	systemdOnce     sync.Once
+	systemdDetected bool
+
+	// version is only set once it has been queried successfully
+	versionMu sync.Mutex
+	version   *Version
+
+	mu       sync.Mutex
+	procs    map[*exec.Cmd]*process
+	shutdown bool
+	// logging holds the commands writing the JSON log of r
+	logging map[*process]struct{}
+	// sem holds a token for each running command when MaxConcurrent is set
+	sem chan struct{}
+}
+
+// stateMu guards the allocation of the state of every Runc
+var stateMu sync.Mutex
+
+// state returns the state of r, allocating it on first use
+func (r *Runc) state() *runcState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if r.st == nil {
+		r.st = &runcState{}
+	}
+	return r.st
 }
 
 // List returns all containers created inside the provided runc root directory
 func (r *Runc) List(context context.Context) ([]*Container, error) {
-	data, err := r.cmdOutput(r.command(context, "list", "--format=json"), false, nil)
+	var out []*Container
+	err := r.retry(context, func() error {
+		data, err := r.cmdOutput(context, r.command(context, "list", "--format=json"), false, nil)
+		defer putBuf(data)
+		if err != nil {
+			return err
+		}
+		out, err = parseList(data.Bytes(), r.unmarshal)
+		return err
+	})
+	return out, err
+}
+
+// parseList decodes the output of `runc list --format=json`
+func parseList(data []byte, unmarshal func([]byte, interface{}) error) ([]*Container, error) {
+	var out []*Container
+	if err := unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListIDs returns the ids of all the containers created inside the provided
+// runc root directory, without decoding their state
+func (r *Runc) ListIDs(context context.Context) ([]string, error) {
+	data, err := r.cmdOutput(context, r.command(context, "list", "--quiet"), false, nil)
 	defer putBuf(data)
 	if err != nil {
 		return nil, err
 	}
-	var out []*Container
-	if err := json.Unmarshal(data.Bytes(), &out); err != nil {
+	return strings.Fields(data.String()), nil
+}
+
+// ListByPrefix returns the containers whose id starts with prefix
+func (r *Runc) ListByPrefix(context context.Context, prefix string) ([]*Container, error) {
+	containers, err := r.List(context)
+	if err != nil {
 		return nil, err
 	}
+	var out []*Container
+	for _, c := range containers {
+		if strings.HasPrefix(c.ID, prefix) {
+			out = append(out, c)
+		}
+	}
 	return out, nil
 }
 
 // State returns the state for the container provided by id
 func (r *Runc) State(context context.Context, id string) (*Container, error) {
-	data, err := r.cmdOutput(r.command(context, "state", id), true, nil)
-	defer putBuf(data)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data.String())
-	}
+	var c *Container
+	err := r.retry(context, func() error {
+		data, err := r.cmdOutput(context, r.command(context, "state", id), true, nil)
+		defer putBuf(data)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, r.errorOutput(data.Bytes()))
+		}
+		c, err = parseState(data.Bytes(), r.unmarshal)
+		return err
+	})
+	return c, err
+}
+
+// parseState decodes the output of `runc state`
+func parseState(data []byte, unmarshal func([]byte, interface{}) error) (*Container, error) {
 	var c Container
-	if err := json.Unmarshal(data.Bytes(), &c); err != nil {
+	if err := unmarshal(data, &c); err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
 
+// marshal encodes v with the JSONMarshal of r, or encoding/json
+func (r *Runc) marshal(v interface{}) ([]byte, error) {
+	if r.JSONMarshal != nil {
+		return r.JSONMarshal(v)
+	}
+	return json.Marshal(v)
+}
+
+// unmarshal decodes data into v with the JSONUnmarshal of r, or
+// encoding/json
+func (r *Runc) unmarshal(data []byte, v interface{}) error {
+	if r.JSONUnmarshal != nil {
+		return r.JSONUnmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Owner returns the user owning the container, which is empty unless the
+// container is rootless
+func (r *Runc) Owner(context context.Context, id string) (string, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return "", err
+	}
+	return c.Owner, nil
+}
+
+// Prune force deletes the stopped containers and returns their ids. The
+// returned error joins the errors of each container which could not be
+// deleted, the ids of those are not returned.
+func (r *Runc) Prune(context context.Context) ([]string, error) {
+	containers, err := r.List(context)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		pruned []string
+		errs   []error
+	)
+	for _, c := range containers {
+		if c.Status != StatusStopped {
+			continue
+		}
+		if err := r.Delete(context, c.ID, &DeleteOpts{Force: true}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.ID, err))
+			continue
+		}
+		pruned = append(pruned, c.ID)
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// Bundle returns the path of the bundle the container was created from
+func (r *Runc) Bundle(context context.Context, id string) (string, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return "", err
+	}
+	return c.Bundle, nil
+}
+
+// States returns the state of each of the containers provided by ids,
+// querying at most batchConcurrency of them at once. The states which could
+// be fetched are returned even if others failed, in which case the
+// returned error joins the errors of each failed id.
+func (r *Runc) States(context context.Context, ids []string) (map[string]*Container, error) {
+	var (
+		mu     sync.Mutex
+		states = make(map[string]*Container, len(ids))
+		errs   []error
+	)
+	forEachConcurrent(ids, batchConcurrency, func(id string) {
+		c, err := r.State(context, id)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			return
+		}
+		states[id] = c
+	})
+	return states, errors.Join(errs...)
+}
+
 // ConsoleSocket handles the path of the socket for console access
 type ConsoleSocket interface {
 	Path() string
@@ -138,6 +382,146 @@ type CreateOpts struct {
 	ExtraFiles    []*os.File
 	Started       chan<- int
 	ExtraArgs     []string
+	// ExitNotify, if set, receives a value once the init process of the
+	// container has exited. The write end of a pipe is passed to the init
+	// process, after ExtraFiles, and the exit is noticed when it is closed
+	// or written to. Processes inheriting the fd delay the notification.
+	// The channel must be buffered: the value is sent without blocking and
+	// dropped if the channel is full.
+	ExitNotify chan<- struct{}
+	// Warnings, if set, receives the warnings about likely misconfigurations
+	// found before the container is created. Warnings are dropped when the
+	// channel is not ready to receive them.
+	Warnings chan<- string
+	// IgnoreExisting makes Create succeed without doing anything when a
+	// container with the same id already exists, so that it can be retried.
+	IgnoreExisting bool
+	// MountLabel and ProcessLabel, if set, are written to the spec of the
+	// bundle as its SELinux mount label and process label before the
+	// container is created.
+	MountLabel   string
+	ProcessLabel string
+	// OnExit, if set, is called by a foreground Run once the container has
+	// exited and runc has deleted it, with the exit status returned by Run,
+	// for the host-side cleanup of the container. It is not called when runc
+	// could not be started, nor by a detached Run, whose container is still
+	// running, nor by Create.
+	OnExit func(status int)
+	// Heartbeat, if set, is called every HeartbeatInterval while create
+	// runs, with the time elapsed since it was started, so that a slow
+	// create can be reported as still in progress. HeartbeatInterval
+	// defaults to a second. It is not called by Run.
+	Heartbeat         func(elapsed time.Duration)
+	HeartbeatInterval time.Duration
+}
+
+// withDefaults returns the options with their unset fields taken from d.
+// Booleans can't be unset by o, so they are enabled if enabled in either.
+func (o *CreateOpts) withDefaults(d *CreateOpts) *CreateOpts {
+	if d == nil {
+		return o
+	}
+	m := *d
+	if o == nil {
+		return &m
+	}
+	if o.IO != nil {
+		m.IO = o.IO
+	}
+	if o.PidFile != "" {
+		m.PidFile = o.PidFile
+	}
+	if o.ConsoleSocket != nil {
+		m.ConsoleSocket = o.ConsoleSocket
+	}
+	m.Detach = m.Detach || o.Detach
+	m.NoPivot = m.NoPivot || o.NoPivot
+	m.NoNewKeyring = m.NoNewKeyring || o.NoNewKeyring
+	if o.ExtraFiles != nil {
+		m.ExtraFiles = o.ExtraFiles
+	}
+	if o.Started != nil {
+		m.Started = o.Started
+	}
+	if o.ExtraArgs != nil {
+		m.ExtraArgs = o.ExtraArgs
+	}
+	if o.ExitNotify != nil {
+		m.ExitNotify = o.ExitNotify
+	}
+	if o.Warnings != nil {
+		m.Warnings = o.Warnings
+	}
+	m.IgnoreExisting = m.IgnoreExisting || o.IgnoreExisting
+	if o.MountLabel != "" {
+		m.MountLabel = o.MountLabel
+	}
+	if o.ProcessLabel != "" {
+		m.ProcessLabel = o.ProcessLabel
+	}
+	if o.OnExit != nil {
+		m.OnExit = o.OnExit
+	}
+	if o.Heartbeat != nil {
+		m.Heartbeat = o.Heartbeat
+	}
+	if o.HeartbeatInterval != 0 {
+		m.HeartbeatInterval = o.HeartbeatInterval
+	}
+	return &m
+}
+
+// WithIO returns a copy of the options using io, leaving o unchanged, so
+// the same options can be used for calls with different IO. A nil o stands
+// for options which only set IO.
+func (o *CreateOpts) WithIO(io IO) *CreateOpts {
+	var m CreateOpts
+	if o != nil {
+		m = *o
+	}
+	m.IO = io
+	return &m
+}
+
+// warn sends the warning to Warnings without blocking
+func (o *CreateOpts) warn(format string, args ...interface{}) {
+	if o.Warnings == nil {
+		return
+	}
+	select {
+	case o.Warnings <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+
+// validate warns about the options which likely fail with the bundle's spec
+func (o *CreateOpts) validate(bundle string) {
+	if o.Warnings == nil || !o.NoPivot {
+		return
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return
+	}
+	if spec.Root != nil && spec.Root.Readonly {
+		o.warn("NoPivot is set but the rootfs of %s is read-only, which likely needs pivot_root", bundle)
+	}
+}
+
+// applyLabels writes the SELinux labels of the options to the spec of the
+// bundle
+func (o *CreateOpts) applyLabels(bundle string) error {
+	if o.MountLabel == "" && o.ProcessLabel == "" {
+		return nil
+	}
+	fields := make(map[string]map[string]string)
+	if o.MountLabel != "" {
+		fields["linux"] = map[string]string{"mountLabel": o.MountLabel}
+	}
+	if o.ProcessLabel != "" {
+		fields["process"] = map[string]string{"selinuxLabel": o.ProcessLabel}
+	}
+	return patchSpec(bundle, fields)
 }
 
 func (o *CreateOpts) args() (out []string, err error) {
@@ -169,25 +553,346 @@ func (o *CreateOpts) args() (out []string, err error) {
 	return out, nil
 }
 
-func (r *Runc) startCommand(cmd *exec.Cmd) (chan Exit, error) {
+// ErrShutdown is returned when a command is run after Shutdown was called
+var ErrShutdown = errors.New("runc client is shut down")
+
+// commandKind tells how long a runc command is expected to run
+type commandKind int
+
+const (
+	// shortCommand returns once runc has handled the request. Only the
+	// short commands are bounded by the Timeout and MaxConcurrent of r.
+	shortCommand commandKind = iota
+	// longCommand runs as long as a container process, like a foreground
+	// run, or streams events, and only its context bounds it
+	longCommand
+)
+
+// kindOf returns the kind of a command running a process in the foreground
+// unless detach is set
+func kindOf(detach bool) commandKind {
+	if detach {
+		return shortCommand
+	}
+	return longCommand
+}
+
+// startCommand starts cmd with the Monitor and tracks it until it has been
+// waited on, so that Shutdown can terminate it
+func (r *Runc) startCommand(context context.Context, cmd *exec.Cmd, kind commandKind) (chan Exit, error) {
+	st := r.state()
+	st.mu.Lock()
+	shutdown := st.shutdown
+	st.mu.Unlock()
+	if shutdown {
+		return nil, ErrShutdown
+	}
+	var sem chan struct{}
+	if kind == shortCommand {
+		var err error
+		if sem, err = r.acquire(context); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		m   = GetMonitor()
+		p   = &process{monitor: m, start: time.Now(), sem: sem}
+		ec  chan Exit
+		err error
+	)
+	// the commands writing the JSON log at the same time are known from the
+	// offset on, as runc doesn't tell which command logged an entry
+	st.mu.Lock()
+	if p.logOffset = r.logOffset(); p.logOffset >= 0 {
+		if st.logging == nil {
+			st.logging = make(map[*process]struct{})
+		}
+		for q := range st.logging {
+			q.sharedLog, p.sharedLog = true, true
+		}
+		st.logging[p] = struct{}{}
+	}
+	st.mu.Unlock()
 	if r.PdeathSignal != 0 {
-		return Monitor.StartLocked(cmd)
+		ec, err = m.StartLocked(cmd)
+	} else {
+		ec, err = m.Start(cmd)
+	}
+	if err != nil {
+		st.mu.Lock()
+		delete(st.logging, p)
+		st.mu.Unlock()
+		release(sem)
+		if r.Metrics != nil {
+			r.Metrics(r.subcommand(cmd), time.Since(p.start), err)
+		}
+		return nil, err
+	}
+
+	if r.Timeout > 0 && kind == shortCommand {
+		p.timer = time.AfterFunc(r.Timeout, func() {
+			p.timedOut.Store(true)
+			cmd.Process.Kill()
+		})
+	}
+	st.mu.Lock()
+	if st.procs == nil {
+		st.procs = make(map[*exec.Cmd]*process)
 	}
-	return Monitor.Start(cmd)
+	st.procs[cmd] = p
+	// Shutdown may have been called while the command was starting
+	shutdown = st.shutdown
+	st.mu.Unlock()
+	if shutdown {
+		cmd.Process.Kill()
+	}
+	return ec, nil
+}
+
+// wait waits for a command started with startCommand to exit and stops
+// tracking it. When r writes a JSON log, the error of a failed command
+// carries the message of the last error runc logged, as long as no other
+// command of r was writing the log meanwhile.
+func (r *Runc) wait(cmd *exec.Cmd, ec chan Exit) (int, error) {
+	st := r.state()
+	st.mu.Lock()
+	p := st.procs[cmd]
+	st.mu.Unlock()
+	// wait with the monitor which started the command, even if it has been
+	// replaced since
+	m := GetMonitor()
+	if p != nil {
+		m = p.monitor
+	}
+	status, err := m.Wait(cmd, ec)
+	st.mu.Lock()
+	delete(st.procs, cmd)
+	shared := false
+	if p != nil {
+		delete(st.logging, p)
+		shared = p.sharedLog
+	}
+	st.mu.Unlock()
+	if p != nil && p.timer != nil {
+		p.timer.Stop()
+	}
+	if p != nil {
+		release(p.sem)
+	}
+	if p != nil && p.timedOut.Load() && (err != nil || status != 0) {
+		// killed at the Timeout of r, reported like a context deadline
+		if err == nil {
+			err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+		}
+		err = fmt.Errorf("%w: %w", err, context.DeadlineExceeded)
+	}
+	if err == nil && status != 0 && p != nil && p.logOffset >= 0 && !shared {
+		// fold the reason runc logged into the error, unless another
+		// command could have logged it
+		if msg := r.lastLogError(p.logOffset); msg != "" {
+			err = fmt.Errorf("%s did not terminate successfully: %w: %s", cmd.Args[0], &ExitError{status}, msg)
+		}
+	}
+	if r.Metrics != nil && p != nil {
+		merr := err
+		if merr == nil && status != 0 {
+			merr = &ExitError{status}
+		}
+		r.Metrics(r.subcommand(cmd), time.Since(p.start), merr)
+	}
+	return status, err
 }
 
+// subcommand returns the runc subcommand run by cmd
+func (r *Runc) subcommand(cmd *exec.Cmd) string {
+	// the runc binary and the global flags come first
+	if i := 1 + len(r.args()); i < len(cmd.Args) {
+		return cmd.Args[i]
+	}
+	return ""
+}
+
+// process holds the bookkeeping of a command started by a Runc
+type process struct {
+	monitor   ProcessMonitor
+	start     time.Time
+	logOffset int64
+	// sharedLog is set when another command wrote the log at the same time
+	sharedLog bool
+	timer     *time.Timer
+	// timedOut is set when the timer kills the command
+	timedOut atomic.Bool
+	// sem is the semaphore the command holds a token of, if any
+	sem chan struct{}
+}
+
+// acquire waits for a token of the semaphore bounding the commands run at
+// once to the MaxConcurrent of r, or for the context to be done, and returns
+// the semaphore. It returns nil when the commands are not bounded.
+func (r *Runc) acquire(context context.Context) (chan struct{}, error) {
+	if r.MaxConcurrent <= 0 {
+		return nil, nil
+	}
+	st := r.state()
+	st.mu.Lock()
+	if st.sem == nil {
+		st.sem = make(chan struct{}, r.MaxConcurrent)
+	}
+	sem := st.sem
+	st.mu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return sem, nil
+	case <-context.Done():
+		return nil, context.Err()
+	}
+}
+
+// release returns the token held of sem, if not nil
+func release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// Shutdown kills every runc process started by r which has not exited yet.
+// Once shut down, r refuses to start new commands and returns ErrShutdown.
+func (r *Runc) Shutdown() error {
+	st := r.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.shutdown = true
+	var errs []error
+	for cmd := range st.procs {
+		if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ErrInvalidID is returned when a container is created with an id which
+// runc would reject
+var ErrInvalidID = errors.New("invalid container id")
+
+// idRegexp is the pattern of the ids accepted by runc, copied as is: the
+// class holds the range from "+" to ".", which includes ","
+var idRegexp = regexp.MustCompile(`^[\w+-\.]+$`)
+
+// ValidateID checks that runc accepts the container id: made of letters,
+// digits, "_", "+", ",", "." and "-", and not "." nor "..". Create, Run and
+// Restore check the id before running runc.
+func ValidateID(id string) error {
+	if !idRegexp.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+// ErrContainerExists is wrapped by the errors of Create when a container
+// with the same id already exists
+var ErrContainerExists = errors.New("container already exists")
+
 // Create creates a new container and returns its pid if it was created successfully
 func (r *Runc) Create(context context.Context, id, bundle string, opts *CreateOpts) error {
+	_, err := r.createOrExisting(context, id, bundle, opts)
+	return err
+}
+
+// createOrExisting creates the container like Create, and returns whether
+// IgnoreExisting left an existing container with the same id as is
+func (r *Runc) createOrExisting(context context.Context, id, bundle string, opts *CreateOpts) (bool, error) {
+	opts = opts.withDefaults(r.DefaultCreateOpts)
+	err := withExitNotify(opts, func(opts *CreateOpts) error {
+		return r.create(context, id, bundle, opts)
+	})
+	if opts != nil && opts.IgnoreExisting && errors.Is(err, ErrContainerExists) {
+		return true, nil
+	}
+	return false, err
+}
+
+// existsError wraps ErrContainerExists into err if it reports that the
+// container already exists, as in "container with id exists: <id>"
+func existsError(err error) error {
+	if err == nil || errors.Is(err, ErrContainerExists) {
+		return err
+	}
+	msg := err.Error()
+	// older runc reports "container with given ID already exists"
+	if strings.Contains(msg, "container with id exists") || strings.Contains(msg, "container with given ID already exists") {
+		return fmt.Errorf("%w: %w", ErrContainerExists, err)
+	}
+	return err
+}
+
+// withExitNotify calls fn with opts passing the exit pipe of
+// opts.ExitNotify to the container, and watches the pipe if fn succeeded
+func withExitNotify(opts *CreateOpts, fn func(*CreateOpts) error) error {
+	if opts == nil || opts.ExitNotify == nil {
+		return fn(opts)
+	}
+	if cap(opts.ExitNotify) == 0 {
+		return errors.New("ExitNotify must be a buffered channel")
+	}
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	o := *opts
+	o.ExtraFiles = append(append([]*os.File{}, opts.ExtraFiles...), wr)
+	err = fn(&o)
+	wr.Close()
+	if err != nil {
+		rd.Close()
+		return err
+	}
+	go func() {
+		defer rd.Close()
+		// returns on EOF once the init process exited, or when it writes
+		rd.Read(make([]byte, 1))
+		select {
+		case opts.ExitNotify <- struct{}{}:
+		default:
+		}
+	}()
+	return nil
+}
+
+func (r *Runc) create(context context.Context, id, bundle string, opts *CreateOpts) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if err := checkBundle(bundle); err != nil {
+		return err
+	}
 	args := []string{"create", "--bundle", bundle}
 	if opts == nil {
 		opts = &CreateOpts{}
 	}
+	opts.validate(bundle)
+	if opts.IgnoreExisting && (opts.MountLabel != "" || opts.ProcessLabel != "") {
+		// leave the bundle of the existing container as is
+		if _, err := r.State(context, id); err == nil {
+			return fmt.Errorf("%w: %s", ErrContainerExists, id)
+		}
+	}
+	if err := opts.applyLabels(bundle); err != nil {
+		return err
+	}
 
 	oargs, err := opts.args()
 	if err != nil {
 		return err
 	}
 	args = append(args, oargs...)
+	if args, err = r.applyRuntimeClass(bundle, args); err != nil {
+		return err
+	}
+	if opts.Heartbeat != nil {
+		defer heartbeat(opts.Heartbeat, opts.HeartbeatInterval)()
+	}
 	cmd := r.command(context, append(args, id)...)
 	if opts.IO != nil {
 		opts.Set(cmd)
@@ -195,34 +900,198 @@ func (r *Runc) Create(context context.Context, id, bundle string, opts *CreateOp
 	cmd.ExtraFiles = opts.ExtraFiles
 
 	if cmd.Stdout == nil && cmd.Stderr == nil {
-		data, err := r.cmdOutput(cmd, true, nil)
+		data, err := r.cmdOutput(context, cmd, true, nil)
 		defer putBuf(data)
 		if err != nil {
-			return fmt.Errorf("%s: %s", err, data.String())
+			return existsError(hookError(err, string(r.errorOutput(data.Bytes()))))
 		}
 		return nil
 	}
-	ec, err := r.startCommand(cmd)
+	ec, err := r.startCommand(context, cmd, shortCommand)
 	if err != nil {
-		return err
+		return r.commandError(cmd, err)
 	}
-	if opts.IO != nil {
-		if c, ok := opts.IO.(StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return err
+	cerr := closeAfterStart(opts.IO)
+	status, err := r.wait(cmd, ec)
+	if cerr != nil {
+		return cerr
+	}
+	err = r.exitError(cmd, status, err)
+	// the output went to IO, only the error logged by runc can be checked
+	return existsError(err)
+}
+
+// defaultHeartbeatInterval is the interval of the heartbeat of create
+const defaultHeartbeatInterval = time.Second
+
+// heartbeat calls fn with the elapsed time every interval until the
+// returned function is called, which returns once fn can't be called again
+func heartbeat(fn func(time.Duration), interval time.Duration) func() {
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	var (
+		start   = time.Now()
+		ticker  = time.NewTicker(interval)
+		stopped = make(chan struct{})
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				fn(time.Since(start))
 			}
 		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(stopped)
+		<-done
 	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+}
+
+// ErrHookFailed is returned when runc fails because an OCI hook failed
+type ErrHookFailed struct {
+	// Stage is the hook stage, such as prestart or createRuntime. It is
+	// empty when runc does not report it.
+	Stage string
+	// Output is the failure runc reported for the hook
+	Output string
+	// Err is the error returned by runc
+	Err error
+}
+
+func (e *ErrHookFailed) Error() string {
+	stage := e.Stage
+	if stage == "" {
+		stage = "an OCI"
 	}
-	return err
+	return fmt.Sprintf("%s hook failed: %s: %s", stage, e.Output, e.Err)
+}
+
+func (e *ErrHookFailed) Unwrap() error {
+	return e.Err
+}
+
+// hookFailureRe matches the hook failures reported by runc, such as
+// "error running prestart hook #0: exit status 1" or, with older runc,
+// "error running hook #0: exit status 1"
+var hookFailureRe = regexp.MustCompile(`error running (?:(\w+) )?hook #\d+: (.*)`)
+
+// hookError returns err, the failure of runc with the given output, as an
+// *ErrHookFailed when the output reports a hook failure
+func hookError(err error, output string) error {
+	m := hookFailureRe.FindStringSubmatch(output)
+	if m == nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return &ErrHookFailed{
+		Stage: m[1],
+		// runc logs the error as the quoted msg of a logfmt line
+		Output: strings.TrimSuffix(strings.TrimSpace(m[2]), `"`),
+		Err:    err,
+	}
+}
+
+// CreateResult holds the information about a container created by CreateEx
+type CreateResult struct {
+	// Pid is the pid of the container's init process
+	Pid int
+	// ConsoleSocket is the path of the console socket passed to runc, if any
+	ConsoleSocket string
+	// ConfigHash is the BundleHash of the bundle the container was created
+	// from, allowing to detect later changes of its spec. It is empty if the
+	// spec could not be read back.
+	ConfigHash string
+}
+
+// CreateEx creates a new container like Create and returns the pid of its
+// init process along with its console information. When opts has no
+// PidFile, a temporary one is used. When IgnoreExisting leaves an existing
+// container as is, the result is read from its state.
+func (r *Runc) CreateEx(context context.Context, id, bundle string, opts *CreateOpts) (*CreateResult, error) {
+	var o CreateOpts
+	if opts != nil {
+		o = *opts
+	}
+	if o.PidFile == "" {
+		dir, err := os.MkdirTemp(os.Getenv("XDG_RUNTIME_DIR"), "runc-create")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		o.PidFile = filepath.Join(dir, "init.pid")
+	}
+	existed, err := r.createOrExisting(context, id, bundle, &o)
+	if err != nil {
+		return nil, err
+	}
+	if existed {
+		// runc wrote no pid file, and the container may have been created
+		// from another bundle
+		c, err := r.State(context, id)
+		if err != nil {
+			return nil, err
+		}
+		hash, _ := BundleHash(c.Bundle)
+		return &CreateResult{Pid: c.Pid, ConfigHash: hash}, nil
+	}
+	pid, err := ReadPidFile(o.PidFile)
+	if err != nil {
+		return nil, err
+	}
+	// the container has been created, a spec which can't be hashed is no
+	// reason to fail
+	hash, _ := BundleHash(bundle)
+	result := &CreateResult{
+		Pid:        pid,
+		ConfigHash: hash,
+	}
+	if o.ConsoleSocket != nil {
+		result.ConsoleSocket = o.ConsoleSocket.Path()
+	}
+	return result, nil
 }
 
 // Start will start an already created container
 func (r *Runc) Start(context context.Context, id string) error {
-	return r.runOrError(r.command(context, "start", id))
+	return r.runOrError(context, r.command(context, "start", id))
+}
+
+// rollbackTimeout bounds the deletion of a container which failed to start
+const rollbackTimeout = 10 * time.Second
+
+// rollbackContext returns the context of a deletion cleaning up after a
+// failed call, which isn't cancelled along with the context of the call
+func rollbackContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), rollbackTimeout)
+}
+
+// CreateAndStart creates the container and starts it, leaving it running.
+// If the container fails to start, the created container is force deleted
+// so that no half-created container is left behind, and the returned error
+// joins the error of the deletion if it failed too. The deletion is bounded
+// by rollbackTimeout rather than the context, which may be done already.
+// An existing container left as is by IgnoreExisting is neither started
+// nor deleted.
+func (r *Runc) CreateAndStart(context context.Context, id, bundle string, opts *CreateOpts) error {
+	existed, err := r.createOrExisting(context, id, bundle, opts)
+	if err != nil || existed {
+		return err
+	}
+	if err := r.Start(context, id); err != nil {
+		rctx, cancel := rollbackContext()
+		defer cancel()
+		if derr := r.Delete(rctx, id, &DeleteOpts{Force: true}); derr != nil {
+			return errors.Join(err, fmt.Errorf("deleting %s after failing to start it: %w", id, derr))
+		}
+		return err
+	}
+	return nil
 }
 
 // ExecOpts holds optional settings when starting an exec process with runc
@@ -233,6 +1102,85 @@ type ExecOpts struct {
 	Detach        bool
 	Started       chan<- int
 	ExtraArgs     []string
+	// Env is merged onto the Env of the process spec. Variables in Env
+	// override the ones of the spec with the same name.
+	Env []string
+	// MaxOutputBytes limits how much of the output is captured when no IO
+	// is set. Output beyond the limit is discarded and the returned error
+	// wraps ErrOutputTruncated.
+	MaxOutputBytes int
+	// User, if set, is resolved with the passwd and group files of the
+	// container's rootfs to set the uid and gid of the process spec, see
+	// ResolveUser.
+	User string
+	// ResolveGroups also sets the supplementary groups of User.
+	ResolveGroups bool
+	// KillTimeout, if non-zero, makes a cancelled exec first receive
+	// SIGTERM, and SIGKILL only if it is still running after the timeout.
+	KillTimeout time.Duration
+	// IgnorePaused allows executing the process in a paused container,
+	// which runc otherwise refuses, see ErrContainerPaused. runc older than
+	// v1.1.0 fails with an *ErrUnsupportedByVersion.
+	IgnorePaused bool
+	// ProcessFromStdin passes the process spec to runc on its stdin instead
+	// of writing it to a temporary file, for hosts without a writable
+	// runtime directory. The process then has no stdin, so IO must not set
+	// one.
+	ProcessFromStdin bool
+}
+
+// withDefaults returns the options with their unset fields taken from d.
+// Booleans can't be unset by o, so they are enabled if enabled in either,
+// and Env is merged onto the Env of d.
+func (o *ExecOpts) withDefaults(d *ExecOpts) *ExecOpts {
+	if d == nil {
+		return o
+	}
+	m := *d
+	if o == nil {
+		return &m
+	}
+	if o.IO != nil {
+		m.IO = o.IO
+	}
+	if o.PidFile != "" {
+		m.PidFile = o.PidFile
+	}
+	if o.ConsoleSocket != nil {
+		m.ConsoleSocket = o.ConsoleSocket
+	}
+	m.Detach = m.Detach || o.Detach
+	if o.Started != nil {
+		m.Started = o.Started
+	}
+	if o.ExtraArgs != nil {
+		m.ExtraArgs = o.ExtraArgs
+	}
+	m.Env = mergeEnv(d.Env, o.Env)
+	if o.MaxOutputBytes != 0 {
+		m.MaxOutputBytes = o.MaxOutputBytes
+	}
+	if o.User != "" {
+		m.User = o.User
+	}
+	m.ResolveGroups = m.ResolveGroups || o.ResolveGroups
+	if o.KillTimeout != 0 {
+		m.KillTimeout = o.KillTimeout
+	}
+	m.IgnorePaused = m.IgnorePaused || o.IgnorePaused
+	m.ProcessFromStdin = m.ProcessFromStdin || o.ProcessFromStdin
+	return &m
+}
+
+// WithIO returns a copy of the options using io, leaving o unchanged, like
+// CreateOpts.WithIO
+func (o *ExecOpts) WithIO(io IO) *ExecOpts {
+	var m ExecOpts
+	if o != nil {
+		m = *o
+	}
+	m.IO = io
+	return &m
 }
 
 func (o *ExecOpts) args() (out []string, err error) {
@@ -249,32 +1197,85 @@ func (o *ExecOpts) args() (out []string, err error) {
 		}
 		out = append(out, "--pid-file", abs)
 	}
+	if o.IgnorePaused {
+		out = append(out, "--ignore-paused")
+	}
 	if len(o.ExtraArgs) > 0 {
 		out = append(out, o.ExtraArgs...)
 	}
 	return out, nil
 }
 
+// ErrContainerPaused is wrapped by the error of Exec when runc refuses to
+// exec in a paused container, which runc does since v1.1 unless
+// ExecOpts.IgnorePaused is set
+var ErrContainerPaused = errors.New("container is paused")
+
+// pausedError wraps ErrContainerPaused into err if it reports that the
+// container is paused, as in "cannot exec in a paused container (use
+// --ignore-paused to override)"
+func pausedError(err error) error {
+	if err == nil || errors.Is(err, ErrContainerPaused) {
+		return err
+	}
+	if strings.Contains(err.Error(), "cannot exec in a paused container") {
+		return fmt.Errorf("%w: %w", ErrContainerPaused, err)
+	}
+	return err
+}
+
 // Exec executes an additional process inside the container based on a full
 // OCI Process specification
 func (r *Runc) Exec(context context.Context, id string, spec specs.Process, opts *ExecOpts) error {
+	opts = opts.withDefaults(r.DefaultExecOpts)
 	if opts == nil {
 		opts = &ExecOpts{}
 	}
 	if opts.Started != nil {
 		defer close(opts.Started)
 	}
-	f, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), "runc-process")
-	if err != nil {
-		return err
+	if len(opts.Env) > 0 {
+		spec.Env = mergeEnv(spec.Env, opts.Env)
 	}
-	defer os.Remove(f.Name())
-	err = json.NewEncoder(f).Encode(spec)
-	f.Close()
+	if opts.User != "" {
+		c, err := r.State(context, id)
+		if err != nil {
+			return err
+		}
+		u, err := ResolveUser(c.Rootfs, opts.User, opts.ResolveGroups)
+		if err != nil {
+			return err
+		}
+		spec.User.UID, spec.User.GID = u.UID, u.GID
+		if opts.ResolveGroups {
+			spec.User.AdditionalGids = u.AdditionalGids
+		}
+	}
+	data, err := r.marshal(spec)
 	if err != nil {
 		return err
 	}
-	args := []string{"exec", "--process", f.Name()}
+	process := "/dev/stdin"
+	if !opts.ProcessFromStdin {
+		// name the file after the container to tell whose it is when left
+		// behind, unless the id can't be part of a file name
+		pattern := "runc-process-*.json"
+		if ValidateID(id) == nil {
+			pattern = "runc-process-" + id + "-*.json"
+		}
+		f, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), pattern)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(data)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		process = f.Name()
+	}
+	args := []string{"exec", "--process", process}
 	oargs, err := opts.args()
 	if err != nil {
 		return err
@@ -284,73 +1285,262 @@ func (r *Runc) Exec(context context.Context, id string, spec specs.Process, opts
 	if opts.IO != nil {
 		opts.Set(cmd)
 	}
+	if opts.ProcessFromStdin {
+		if cmd.Stdin != nil {
+			return errors.New("the process spec can't be passed on stdin when IO sets stdin")
+		}
+		cmd.Stdin = bytes.NewReader(data)
+	}
+	if opts.KillTimeout > 0 {
+		// runc exec forwards the signal to the process
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+		cmd.WaitDelay = opts.KillTimeout
+	}
 	if cmd.Stdout == nil && cmd.Stderr == nil {
-		data, err := r.cmdOutput(cmd, true, opts.Started)
+		data, truncated, err := r.cmdOutputLimit(context, cmd, true, opts.Started, opts.MaxOutputBytes, kindOf(opts.Detach))
 		defer putBuf(data)
 		if err != nil {
-			return fmt.Errorf("%w: %s", err, data.String())
+			if truncated {
+				return r.execError(context, opts, fmt.Errorf("%w: %s: %w", err, r.errorOutput(data.Bytes()), ErrOutputTruncated))
+			}
+			return r.execError(context, opts, pausedError(fmt.Errorf("%w: %s", err, r.errorOutput(data.Bytes()))))
 		}
 		return nil
 	}
-	ec, err := r.startCommand(cmd)
+	ec, err := r.startCommand(context, cmd, kindOf(opts.Detach))
 	if err != nil {
-		return err
+		return r.commandError(cmd, err)
 	}
 	if opts.Started != nil {
 		opts.Started <- cmd.Process.Pid
 	}
-	if opts.IO != nil {
-		if c, ok := opts.IO.(StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return err
-			}
-		}
+	cerr := closeAfterStart(opts.IO)
+	status, err := r.wait(cmd, ec)
+	if cerr != nil {
+		return cerr
 	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+	err = r.exitError(cmd, status, err)
+	// the output went to IO, only the error logged by runc can be checked
+	return r.execError(context, opts, pausedError(err))
+}
+
+// execError turns the error of a failed exec into an
+// *ErrUnsupportedByVersion when it used a flag the runc version lacks
+func (r *Runc) execError(context context.Context, opts *ExecOpts, err error) error {
+	if err == nil || !opts.IgnorePaused {
+		return err
 	}
-	return err
+	return r.unsupportedByVersion(context, err, "--ignore-paused", "1.1.0")
 }
 
 // Run runs the create, start, delete lifecycle of the container
-// and returns its exit status after it has exited
+// and returns its exit status after it has exited.
+//
+// Without IO, the output of a foreground run is captured for its error, up
+// to 1MiB. The stdout of a detached run is discarded, and its stderr still
+// captured for its error.
 func (r *Runc) Run(context context.Context, id, bundle string, opts *CreateOpts) (int, error) {
+	var status int
+	opts = opts.withDefaults(r.DefaultCreateOpts)
+	err := withExitNotify(opts, func(opts *CreateOpts) (err error) {
+		status, err = r.run(context, id, bundle, opts)
+		return err
+	})
+	return status, err
+}
+
+// RunCaptured runs the container in the foreground like Run, and returns
+// its exit status along with everything it wrote to stdout and stderr. The
+// output is buffered in memory, so it is meant for one-shot jobs with a
+// bounded output. The options can't set IO, nor Detach.
+func (r *Runc) RunCaptured(context context.Context, id, bundle string, opts *CreateOpts) (status int, stdout, stderr []byte, err error) {
+	var o CreateOpts
+	if opts != nil {
+		o = *opts
+	}
+	if o.IO != nil || o.Detach {
+		return -1, nil, nil, errors.New("RunCaptured can't be used with IO nor Detach")
+	}
+	b := &bufferIO{}
+	o.IO = b
+	status, err = r.Run(context, id, bundle, &o)
+	return status, b.stdout.Bytes(), b.stderr.Bytes(), err
+}
+
+func (r *Runc) run(context context.Context, id, bundle string, opts *CreateOpts) (status int, err error) {
 	if opts == nil {
 		opts = &CreateOpts{}
 	}
 	if opts.Started != nil {
 		defer close(opts.Started)
 	}
+	if err := ValidateID(id); err != nil {
+		return -1, err
+	}
+	if err := checkBundle(bundle); err != nil {
+		return -1, err
+	}
+	opts.validate(bundle)
+	if err := opts.applyLabels(bundle); err != nil {
+		return -1, err
+	}
 	args := []string{"run", "--bundle", bundle}
 	oargs, err := opts.args()
 	if err != nil {
 		return -1, err
 	}
 	args = append(args, oargs...)
+	if args, err = r.applyRuntimeClass(bundle, args); err != nil {
+		return -1, err
+	}
 	cmd := r.command(context, append(args, id)...)
 	if opts.IO != nil {
 		opts.Set(cmd)
 	}
 	cmd.ExtraFiles = opts.ExtraFiles
-	ec, err := r.startCommand(cmd)
+	if opts.OnExit != nil && !opts.Detach {
+		defer func() {
+			// only report the exit of a container which has been run
+			if cmd.Process != nil {
+				opts.OnExit(status)
+			}
+		}()
+	}
+
+	var stderr *os.File
+	if opts.Detach && cmd.Stdout == nil && cmd.Stderr == nil {
+		if stderr, err = detachedOutput(cmd); err != nil {
+			return -1, err
+		}
+		defer stderr.Close()
+	}
+	if cmd.Stdout == nil && cmd.Stderr == nil {
+		data, truncated, err := r.cmdOutputLimit(context, cmd, true, opts.Started, maxRunOutputBytes, longCommand)
+		defer putBuf(data)
+		if err != nil {
+			status := -1
+			var exitErr *ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.Status
+			}
+			if truncated {
+				return status, fmt.Errorf("%w: %s: %w", err, r.errorOutput(data.Bytes()), ErrOutputTruncated)
+			}
+			return status, fmt.Errorf("%w: %s", err, r.errorOutput(data.Bytes()))
+		}
+		return 0, nil
+	}
+	ec, err := r.startCommand(context, cmd, kindOf(opts.Detach))
 	if err != nil {
-		return -1, err
+		return -1, r.commandError(cmd, err)
 	}
 	if opts.Started != nil {
 		opts.Started <- cmd.Process.Pid
 	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+	status, err = r.wait(cmd, ec)
+	err = r.exitError(cmd, status, err)
+	if err != nil && stderr != nil {
+		data, _ := io.ReadAll(io.NewSectionReader(stderr, 0, maxRunOutputBytes))
+		err = fmt.Errorf("%w: %s", err, r.errorOutput(data))
 	}
 	return status, err
 }
 
+// maxRunOutputBytes caps the output of a foreground run without IO, which
+// is captured to be attached to its error
+const maxRunOutputBytes = 1 << 20
+
+// detachedOutput sends the stdout of cmd, a detached run without IO, to
+// /dev/null and its stderr to an unlinked temporary file, returned to read
+// the error of runc and to close once cmd has exited. The container inherits
+// the stdio of runc, so pipes capturing the output would only be closed once
+// it exits, and the wait for runc would last as long.
+func detachedOutput(cmd *exec.Cmd) (*os.File, error) {
+	f, err := os.CreateTemp("", "runc-stderr-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	cmd.Stdout, cmd.Stderr = nil, f
+	return f, nil
+}
+
+// detachedEventsInterval is the interval of the events watched for the exit
+// of a container, which bounds how late the exit is noticed
+const detachedEventsInterval = time.Second
+
+// RunDetached runs the container detached from runc and returns a channel
+// receiving its Exit once it has stopped, see StartMonitored.
+func (r *Runc) RunDetached(context context.Context, id, bundle string, opts *CreateOpts) (chan Exit, error) {
+	var o CreateOpts
+	if opts != nil {
+		o = *opts
+	}
+	o.Detach = true
+	if _, err := r.Run(context, id, bundle, &o); err != nil {
+		return nil, err
+	}
+	return r.watchExit(context, id)
+}
+
+// StartMonitored starts the created container and returns a channel
+// receiving its Exit once its init process has stopped.
+//
+// runc is not the parent of the container and can't report its exit
+// status, so the Exit only carries the pid and the time the exit was
+// noticed, with Status 255. The exit is detected through the end of the
+// events of the container, confirmed by its state, or by polling its state
+// when runc events fails, like with the runtimes without events. The
+// channel is closed without an Exit if the context is done first, or if the
+// state can't be read, like once r is shut down.
+func (r *Runc) StartMonitored(context context.Context, id string) (chan Exit, error) {
+	if err := r.Start(context, id); err != nil {
+		return nil, err
+	}
+	return r.watchExit(context, id)
+}
+
+// watchExit returns a channel receiving the Exit of the running container
+func (r *Runc) watchExit(context context.Context, id string) (chan Exit, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return nil, err
+	}
+	s, err := r.EventsStream(context, id, &EventsOpts{Interval: detachedEventsInterval, BufferSize: 1})
+	if err != nil {
+		return nil, err
+	}
+	ec := make(chan Exit, 1)
+	go func() {
+		defer close(ec)
+		for range s.Events() {
+		}
+		if s.Err() != nil {
+			// runc events failed or was killed, the container may still run
+			if _, err := r.waitStatus(context, id, StatusStopped, nil); err != nil {
+				return
+			}
+		} else if c, err := r.State(context, id); err != nil || c.Status != StatusStopped {
+			return
+		}
+		ec <- Exit{
+			Timestamp: time.Now(),
+			Pid:       c.Pid,
+			Status:    255,
+		}
+	}()
+	return ec, nil
+}
+
 // DeleteOpts holds the deletion options for calling `runc delete`
 type DeleteOpts struct {
 	Force     bool
 	ExtraArgs []string
+	// RemoveBundle is the path of the bundle directory to remove once the
+	// container has been deleted. The bundle is kept if the delete fails.
+	RemoveBundle string
 }
 
 func (o *DeleteOpts) args() (out []string) {
@@ -363,13 +1553,124 @@ func (o *DeleteOpts) args() (out []string) {
 	return out
 }
 
+// forceDeleteRetries is the number of times a forced delete is retried when
+// runc reports that the container's resources are still busy
+const forceDeleteRetries = 5
+
+// forceDeleteRetryInterval is the time waited between forced delete attempts
+var forceDeleteRetryInterval = 100 * time.Millisecond
+
 // Delete deletes the container
+//
+// A forced delete that fails because the container's cgroup is briefly busy
+// (EBUSY) is retried a bounded number of times, as long as the context has
+// not expired, along with the errors retried by the RetryPolicy of r.
 func (r *Runc) Delete(context context.Context, id string, opts *DeleteOpts) error {
 	args := []string{"delete"}
 	if opts != nil {
 		args = append(args, opts.args()...)
 	}
-	return r.runOrError(r.command(context, append(args, id)...))
+	args = append(args, id)
+	policy := r.RetryPolicy
+	if opts != nil && opts.Force {
+		policy = policy.withForceDelete()
+	}
+	err := policy.do(context, func() error {
+		return r.runOrError(context, r.command(context, args...))
+	})
+	if err != nil || opts == nil || opts.RemoveBundle == "" {
+		return err
+	}
+	bundle, err := filepath.Abs(opts.RemoveBundle)
+	if err != nil {
+		return err
+	}
+	if bundle == "/" {
+		return fmt.Errorf("refusing to remove / as the bundle of %s", id)
+	}
+	return os.RemoveAll(bundle)
+}
+
+// RetryPolicy retries the idempotent commands failing with a transient
+// error: List, State, Delete, Pause, Resume, Ps and Update.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a command is run, including
+	// the first attempt
+	MaxAttempts int
+	// Backoff returns the time to wait before the nth retry, starting at 1.
+	// If nil, retries are 100ms apart.
+	Backoff func(n int) time.Duration
+	// Retryable returns whether the error is transient. If nil, errors
+	// reporting a busy resource (EBUSY) are retried.
+	Retryable func(err error) bool
+}
+
+// do calls fn until it succeeds, fails with an error which is not
+// retryable, the attempts are exhausted or the context is done. A nil
+// policy calls fn once.
+func (p *RetryPolicy) do(context context.Context, fn func() error) error {
+	err := fn()
+	if p == nil {
+		return err
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = isBusyError
+	}
+	for n := 1; n < p.MaxAttempts && err != nil && retryable(err); n++ {
+		backoff := 100 * time.Millisecond
+		if p.Backoff != nil {
+			backoff = p.Backoff(n)
+		}
+		select {
+		case <-context.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		err = fn()
+	}
+	return err
+}
+
+// withForceDelete returns the policy retrying the busy errors of a forced
+// delete as well as the errors retried by p
+func (p *RetryPolicy) withForceDelete() *RetryPolicy {
+	merged := &RetryPolicy{
+		MaxAttempts: forceDeleteRetries + 1,
+		Backoff: func(int) time.Duration {
+			return forceDeleteRetryInterval
+		},
+		Retryable: isBusyError,
+	}
+	if p == nil {
+		return merged
+	}
+	if p.MaxAttempts > merged.MaxAttempts {
+		merged.MaxAttempts = p.MaxAttempts
+	}
+	if p.Backoff != nil {
+		merged.Backoff = p.Backoff
+	}
+	if retryable := p.Retryable; retryable != nil {
+		merged.Retryable = func(err error) bool {
+			return isBusyError(err) || retryable(err)
+		}
+	}
+	return merged
+}
+
+// retry runs fn with the RetryPolicy of r
+func (r *Runc) retry(context context.Context, fn func() error) error {
+	return r.RetryPolicy.do(context, fn)
+}
+
+// isBusyError returns true if err reports that a resource was busy
+func isBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "device or resource busy") || strings.Contains(msg, "EBUSY")
 }
 
 // KillOpts specifies options for killing a container and its processes
@@ -396,26 +1697,218 @@ func (r *Runc) Kill(context context.Context, id string, sig int, opts *KillOpts)
 	if opts != nil {
 		args = append(args, opts.args()...)
 	}
-	return r.runOrError(r.command(context, append(args, id, strconv.Itoa(sig))...))
+	return r.runOrError(context, r.command(context, append(args, id, strconv.Itoa(sig))...))
 }
 
-// Stats return the stats for a container like cpu, memory, and io
-func (r *Runc) Stats(context context.Context, id string) (*Stats, error) {
-	cmd := r.command(context, "events", "--stats", id)
-	rd, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+// KillSignal is like Kill, but takes the signal as an os.Signal, which has
+// to be a syscall.Signal
+func (r *Runc) KillSignal(context context.Context, id string, sig os.Signal, opts *KillOpts) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v of type %T", sig, sig)
 	}
-	ec, err := r.startCommand(cmd)
+	return r.Kill(context, id, int(s), opts)
+}
+
+// KillAll sends the signal to each process of the container, as listed by
+// Ps, and returns the pids which were signaled. The returned error joins the
+// errors of each process which could not be signaled, like one which has
+// exited since being listed.
+//
+// Each process is signaled through a pidfd opened while it is still listed
+// by Ps, so that a pid reused by another process is never signaled. Without
+// pidfd support, the signal is sent with "kill --all" and the listed pids
+// are returned.
+func (r *Runc) KillAll(context context.Context, id string, sig int) ([]int, error) {
+	pids, err := r.Ps(context, id)
 	if err != nil {
 		return nil, err
 	}
+	var errs []error
+	fds := make(map[int]pidfd, len(pids))
 	defer func() {
-		rd.Close()
-		Monitor.Wait(cmd, ec)
+		for _, fd := range fds {
+			fd.Close()
+		}
 	}()
+	for _, pid := range pids {
+		fd, err := openPidfd(pid)
+		if err != nil {
+			if errors.Is(err, syscall.ENOSYS) {
+				if err := r.Kill(context, id, sig, &KillOpts{All: true}); err != nil {
+					return nil, err
+				}
+				return pids, nil
+			}
+			errs = append(errs, fmt.Errorf("pid %d: %w", pid, err))
+			continue
+		}
+		fds[pid] = fd
+	}
+	// a pid still listed now refers to the process of its pidfd, or that
+	// process has exited and can't be signaled anymore
+	listed, err := r.Ps(context, id)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[int]bool, len(listed))
+	for _, pid := range listed {
+		current[pid] = true
+	}
+	var signaled []int
+	for _, pid := range pids {
+		fd, ok := fds[pid]
+		if !ok {
+			continue
+		}
+		if !current[pid] {
+			errs = append(errs, fmt.Errorf("pid %d: %w", pid, os.ErrProcessDone))
+			continue
+		}
+		if err := fd.signal(syscall.Signal(sig)); err != nil {
+			errs = append(errs, fmt.Errorf("pid %d: %w", pid, err))
+			continue
+		}
+		signaled = append(signaled, pid)
+	}
+	return signaled, errors.Join(errs...)
+}
+
+// SignalStep is a signal sent to a container by Stop along with the time
+// the container is given to stop before the next step
+type SignalStep struct {
+	Signal  int
+	Timeout time.Duration
+}
+
+// DefaultStopSequence is the sequence sent by Stop without a StopSequence:
+// SIGTERM, then SIGKILL if the container is still running 10s later
+var DefaultStopSequence = []SignalStep{
+	{Signal: int(syscall.SIGTERM), Timeout: 10 * time.Second},
+	{Signal: int(syscall.SIGKILL), Timeout: 10 * time.Second},
+}
+
+// StopOpts specifies options for stopping a container
+type StopOpts struct {
+	// All sends each signal to all the processes of the container
+	All bool
+	// StopSequence is the escalation of signals sent until the container
+	// has stopped. If empty, DefaultStopSequence is used.
+	StopSequence []SignalStep
+}
+
+// ErrStopTimeout is returned by Stop when the container is still running
+// after the whole stop sequence
+var ErrStopTimeout = errors.New("container did not stop")
+
+// defaultStatusPollInterval is the time between the state checks of Stop
+// and WaitForStatus when StatusPollInterval is not set
+const defaultStatusPollInterval = 100 * time.Millisecond
+
+// Stop sends the signals of the stop sequence to the container in turn,
+// until it has stopped. Each signal is given its timeout for the container
+// to stop before the next one is sent.
+func (r *Runc) Stop(context context.Context, id string, opts *StopOpts) error {
+	sequence := DefaultStopSequence
+	var kopts KillOpts
+	if opts != nil {
+		if len(opts.StopSequence) > 0 {
+			sequence = opts.StopSequence
+		}
+		kopts.All = opts.All
+	}
+	for _, step := range sequence {
+		if err := r.Kill(context, id, step.Signal, &kopts); err != nil {
+			if c, serr := r.State(context, id); serr == nil && c.Status == StatusStopped {
+				return nil
+			}
+			return err
+		}
+		timeout := time.NewTimer(step.Timeout)
+		stopped, err := r.waitStatus(context, id, StatusStopped, timeout.C)
+		timeout.Stop()
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return fmt.Errorf("stop %s: %w", id, ErrStopTimeout)
+}
+
+// WaitForStatus polls the state of the container every r.StatusPollInterval
+// until it has the target status, like StatusRunning, or the context is
+// done. As a stopped container can't reach any other status, it fails once
+// the container has stopped while waiting for another status.
+func (r *Runc) WaitForStatus(context context.Context, id string, target string) error {
+	_, err := r.waitStatus(context, id, target, nil)
+	return err
+}
+
+// waitStatus polls the state of the container until it has the target
+// status or timeout fires, returning whether the status was reached
+func (r *Runc) waitStatus(context context.Context, id, target string, timeout <-chan time.Time) (bool, error) {
+	interval := r.StatusPollInterval
+	if interval <= 0 {
+		interval = defaultStatusPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c, err := r.State(context, id)
+		if err != nil {
+			if cerr := context.Err(); cerr != nil {
+				return false, cerr
+			}
+			return false, err
+		}
+		if c.Status == target {
+			return true, nil
+		}
+		if c.Status == StatusStopped {
+			return false, fmt.Errorf("container %s stopped while waiting for status %s", id, target)
+		}
+		select {
+		case <-context.Done():
+			return false, context.Err()
+		case <-timeout:
+			return false, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// KillMany sends the signal to each of the containers provided by ids, like
+// Kill, signaling at most batchConcurrency of them at once. The returned map
+// holds the result of each id, nil for the containers which were signaled.
+func (r *Runc) KillMany(context context.Context, ids []string, sig int, opts *KillOpts) map[string]error {
+	var (
+		mu      sync.Mutex
+		results = make(map[string]error, len(ids))
+	)
+	forEachConcurrent(ids, batchConcurrency, func(id string) {
+		err := r.Kill(context, id, sig, opts)
+		mu.Lock()
+		results[id] = err
+		mu.Unlock()
+	})
+	return results
+}
+
+// Stats return the stats for a container like cpu, memory, and io
+//
+// When `events` fails, e.g. because the runtime doesn't implement it, the
+// stats are read directly from the cgroup of the container on linux.
+func (r *Runc) Stats(context context.Context, id string) (*Stats, error) {
+	data, err := r.cmdOutput(context, r.command(context, "events", "--stats", id), false, nil)
+	defer putBuf(data)
+	if err != nil {
+		// not every runtime implements events, read the cgroup instead
+		if s, cerr := r.cgroupStats(context, id); cerr == nil {
+			return s, nil
+		}
+		return nil, err
+	}
 	var e Event
-	if err := json.NewDecoder(rd).Decode(&e); err != nil {
+	if err := r.unmarshal(data.Bytes(), &e); err != nil {
 		return nil, err
 	}
 	return e.Stats, nil
@@ -423,62 +1916,210 @@ func (r *Runc) Stats(context context.Context, id string) (*Stats, error) {
 
 // Events returns an event stream from runc for a container with stats and OOM notifications
 func (r *Runc) Events(context context.Context, id string, interval time.Duration) (chan *Event, error) {
-	cmd := r.command(context, "events", fmt.Sprintf("--interval=%ds", int(interval.Seconds())), id)
-	rd, err := cmd.StdoutPipe()
+	return r.EventsWithOpts(context, id, &EventsOpts{Interval: interval})
+}
+
+// defaultEventsBufferSize is the size of the events channel buffer when
+// EventsOpts.BufferSize is not set
+const defaultEventsBufferSize = 128
+
+// EventsOpts holds the options for the events stream
+type EventsOpts struct {
+	// Interval is the interval between stats events, it must be positive
+	Interval time.Duration
+	// BufferSize is the size of the events channel buffer, 128 if zero.
+	// While the buffer is full, events are not read from runc.
+	BufferSize int
+	// FilterID, if set, drops the events of any other container. The
+	// errors reading the events are always delivered.
+	FilterID string
+}
+
+// formatInterval formats the interval for the --interval flag of events,
+// which takes a duration with its unit and supports sub-second intervals
+func formatInterval(d time.Duration) (string, error) {
+	switch {
+	case d <= 0:
+		return "", fmt.Errorf("invalid events interval %s, it must be positive", d)
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s", nil
+	case d%time.Millisecond == 0:
+		return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms", nil
+	}
+	return d.String(), nil
+}
+
+// EventsWithOpts returns an event stream from runc for a container with
+// stats and OOM notifications, configured by opts
+func (r *Runc) EventsWithOpts(context context.Context, id string, opts *EventsOpts) (chan *Event, error) {
+	s, err := r.EventsStream(context, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.c, nil
+}
+
+// EventsStream is a stream of events read from runc
+type EventsStream struct {
+	c chan *Event
+
+	mu  sync.Mutex
+	err error
+}
+
+// Events returns the channel receiving the events, which is closed once the
+// stream has terminated
+func (s *EventsStream) Events() <-chan *Event {
+	return s.c
+}
+
+// Err returns the error which terminated the stream once the events channel
+// is closed: the error reading the events or of runc, or else the first
+// event which couldn't be decoded, or nil if every event was read and runc
+// ended the stream cleanly. An event which can't be decoded doesn't
+// terminate the stream, it is sent as an error event as well.
+func (s *EventsStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// EventsStream is like EventsWithOpts, but returns the stream to tell why it
+// terminated
+func (r *Runc) EventsStream(context context.Context, id string, opts *EventsOpts) (*EventsStream, error) {
+	if opts == nil {
+		opts = &EventsOpts{}
+	}
+	size := opts.BufferSize
+	if size <= 0 {
+		size = defaultEventsBufferSize
+	}
+	interval, err := formatInterval(opts.Interval)
 	if err != nil {
 		return nil, err
 	}
-	ec, err := r.startCommand(cmd)
+	cmd := r.command(context, "events", "--interval="+interval, id)
+	// the Monitor may Wait for runc as soon as it exits, which closes the
+	// pipes set up by cmd.StdoutPipe before all the events are read
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = wr
+	ec, err := r.startCommand(context, cmd, longCommand)
+	wr.Close()
 	if err != nil {
 		rd.Close()
 		return nil, err
 	}
 	var (
-		dec = json.NewDecoder(rd)
-		c   = make(chan *Event, 128)
+		br = bufio.NewReader(rd)
+		s  = &EventsStream{c: make(chan *Event, size)}
 	)
 	go func() {
+		var err, decodeErr error
 		defer func() {
-			close(c)
+			if err != nil {
+				// the events can't be read anymore
+				cmd.Process.Kill()
+			}
 			rd.Close()
-			Monitor.Wait(cmd, ec)
+			status, werr := r.wait(cmd, ec)
+			if err == nil {
+				err = werr
+			}
+			if err == nil && status != 0 {
+				err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+			}
+			if err == nil {
+				err = decodeErr
+			}
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			close(s.c)
 		}()
 		for {
-			var e Event
-			if err := dec.Decode(&e); err != nil {
-				if err == io.EOF {
-					return
+			// runc writes an event per line
+			var line []byte
+			line, err = br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var e Event
+				if uerr := r.unmarshal(line, &e); uerr != nil {
+					if decodeErr == nil {
+						decodeErr = uerr
+					}
+					e = Event{
+						Type: EventTypeError,
+						Err:  uerr,
+					}
+				} else if opts.FilterID != "" && e.ID != opts.FilterID {
+					continue
 				}
-				e = Event{
-					Type: "error",
+				e.Raw = bytes.TrimSpace(line)
+				s.c <- &e
+			}
+			if err == io.EOF {
+				err = nil
+				return
+			}
+			if err != nil {
+				s.c <- &Event{
+					Type: EventTypeError,
 					Err:  err,
 				}
+				return
 			}
-			c <- &e
 		}
 	}()
-	return c, nil
+	return s, nil
 }
 
 // Pause the container with the provided id
+//
+// A retried pause which finds the container not running succeeds if it is
+// paused, as the failed attempt may have paused it.
 func (r *Runc) Pause(context context.Context, id string) error {
-	return r.runOrError(r.command(context, "pause", id))
+	return r.retryTransition(context, id, "pause", "not running", StatusPaused)
 }
 
 // Resume the container with the provided id
+//
+// A retried resume which finds the container not paused succeeds if it is
+// running, as the failed attempt may have resumed it.
 func (r *Runc) Resume(context context.Context, id string) error {
-	return r.runOrError(r.command(context, "resume", id))
+	return r.retryTransition(context, id, "resume", "not paused", StatusRunning)
+}
+
+// retryTransition runs the command moving the container to status with the
+// RetryPolicy of r. A retry failing with msg, which runc reports when the
+// container can't be moved, succeeds if the container has the status.
+func (r *Runc) retryTransition(context context.Context, id, command, msg, status string) error {
+	retried := false
+	return r.retry(context, func() error {
+		err := r.runOrError(context, r.command(context, command, id))
+		if err != nil && retried && strings.Contains(err.Error(), msg) {
+			if c, serr := r.State(context, id); serr == nil && c.Status == status {
+				return nil
+			}
+		}
+		retried = true
+		return err
+	})
 }
 
 // Ps lists all the processes inside the container returning their pids
 func (r *Runc) Ps(context context.Context, id string) ([]int, error) {
-	data, err := r.cmdOutput(r.command(context, "ps", "--format", "json", id), true, nil)
-	defer putBuf(data)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data.String())
-	}
 	var pids []int
-	if err := json.Unmarshal(data.Bytes(), &pids); err != nil {
+	err := r.retry(context, func() error {
+		data, err := r.cmdOutput(context, r.command(context, "ps", "--format", "json", id), true, nil)
+		defer putBuf(data)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, r.errorOutput(data.Bytes()))
+		}
+		return r.unmarshal(data.Bytes(), &pids)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return pids, nil
@@ -486,10 +2127,10 @@ func (r *Runc) Ps(context context.Context, id string) ([]int, error) {
 
 // Top lists all the processes inside the container returning the full ps data
 func (r *Runc) Top(context context.Context, id string, psOptions string) (*TopResults, error) {
-	data, err := r.cmdOutput(r.command(context, "ps", "--format", "table", id, psOptions), true, nil)
+	data, err := r.cmdOutput(context, r.command(context, "ps", "--format", "table", id, psOptions), true, nil)
 	defer putBuf(data)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data.String())
+		return nil, fmt.Errorf("%w: %s", err, r.errorOutput(data.Bytes()))
 	}
 
 	topResults, err := ParsePSOutput(data.Bytes())
@@ -503,12 +2144,19 @@ func (r *Runc) Top(context context.Context, id string, psOptions string) (*TopRe
 type CheckpointOpts struct {
 	// ImagePath is the path for saving the criu image file
 	ImagePath string
-	// WorkDir is the working directory for criu
+	// WorkDir is the working directory for criu, where it writes its logs.
+	// When a checkpoint fails, the end of the log is added to the error.
 	WorkDir string
 	// ParentPath is the path for previous image files from a pre-dump
 	ParentPath string
-	// AllowOpenTCP allows open tcp connections to be checkpointed
+	// AllowOpenTCP allows open tcp connections to be checkpointed, with
+	// --tcp-established
 	AllowOpenTCP bool
+	// TCPSkipInFlight skips the connections which are not yet established
+	// when checkpointing, instead of failing on them. It pairs with
+	// AllowOpenTCP and is ignored by restore. runc older than v1.2.0 fails
+	// with an *ErrUnsupportedByVersion.
+	TCPSkipInFlight bool
 	// AllowExternalUnixSockets allows external unix sockets to be checkpointed
 	AllowExternalUnixSockets bool
 	// AllowTerminal allows the terminal(pty) to be checkpointed with a container
@@ -524,7 +2172,9 @@ type CheckpointOpts struct {
 	EmptyNamespaces []string
 	// LazyPages uses userfaultfd to lazily restore memory pages
 	LazyPages bool
-	// StatusFile is the file criu writes \0 to once lazy-pages is ready
+	// StatusFile is the file criu writes \0 to once lazy-pages is ready.
+	// It is passed to runc as --status-fd, so callers holding the read end
+	// of a pipe can observe the progress of the checkpoint.
 	StatusFile *os.File
 	ExtraArgs  []string
 }
@@ -600,6 +2250,10 @@ func (r *Runc) Checkpoint(context context.Context, id string, opts *CheckpointOp
 	extraFiles := []*os.File{}
 	if opts != nil {
 		args = append(args, opts.args()...)
+		if opts.TCPSkipInFlight {
+			// only checkpoint has the flag, restore shares the other options
+			args = append(args, "--tcp-skip-in-flight")
+		}
 		if opts.StatusFile != nil {
 			// pass the status file to the child process
 			extraFiles = []*os.File{opts.StatusFile}
@@ -613,7 +2267,46 @@ func (r *Runc) Checkpoint(context context.Context, id string, opts *CheckpointOp
 	}
 	cmd := r.command(context, append(args, id)...)
 	cmd.ExtraFiles = extraFiles
-	return r.runOrError(cmd)
+	if err := r.runOrError(context, cmd); err != nil {
+		err = withCriuLog(err, opts, "dump.log")
+		if opts != nil && opts.TCPSkipInFlight {
+			return r.unsupportedByVersion(context, err, "--tcp-skip-in-flight", "1.2.0")
+		}
+		return err
+	}
+	return nil
+}
+
+// maxCriuLogBytes is the size of the tail of the criu log folded into errors
+const maxCriuLogBytes = 4096
+
+// withCriuLog folds the tail of the criu log written to the work directory,
+// or to the image directory when no work directory is set, into err. err is
+// returned as is if there is no log to read.
+func withCriuLog(err error, opts *CheckpointOpts, name string) error {
+	if opts == nil {
+		return err
+	}
+	dir := opts.WorkDir
+	if dir == "" {
+		dir = opts.ImagePath
+	}
+	if dir == "" {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	data, rerr := os.ReadFile(path)
+	if rerr != nil || len(data) == 0 {
+		return err
+	}
+	if len(data) > maxCriuLogBytes {
+		data = data[len(data)-maxCriuLogBytes:]
+		// drop the partial first line
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	return fmt.Errorf("%w: %s: %s", err, path, bytes.TrimSpace(data))
 }
 
 // RestoreOpts holds the options for performing a criu restore using runc
@@ -656,8 +2349,15 @@ func (o *RestoreOpts) args() ([]string, error) {
 	return out, nil
 }
 
-// Restore restores a container with the provide id from an existing checkpoint
+// Restore restores a container with the provide id from an existing checkpoint.
+//
+// The container is restored as id from bundle, neither of which has to match
+// the container the checkpoint was taken from, e.g. to migrate the container
+// under a new id.
 func (r *Runc) Restore(context context.Context, id, bundle string, opts *RestoreOpts) (int, error) {
+	if err := ValidateID(id); err != nil {
+		return -1, err
+	}
 	args := []string{"restore"}
 	if opts != nil {
 		oargs, err := opts.args()
@@ -671,51 +2371,79 @@ func (r *Runc) Restore(context context.Context, id, bundle string, opts *Restore
 	if opts != nil && opts.IO != nil {
 		opts.Set(cmd)
 	}
-	ec, err := r.startCommand(cmd)
+	kind := longCommand
+	if opts != nil {
+		kind = kindOf(opts.Detach)
+	}
+	ec, err := r.startCommand(context, cmd, kind)
 	if err != nil {
-		return -1, err
+		return -1, r.commandError(cmd, err)
 	}
-	if opts != nil && opts.IO != nil {
-		if c, ok := opts.IO.(StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return -1, err
-			}
-		}
+	var cerr error
+	if opts != nil {
+		cerr = closeAfterStart(opts.IO)
 	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+	status, err := r.wait(cmd, ec)
+	if cerr != nil {
+		return -1, cerr
 	}
+	err = r.exitError(cmd, status, err)
 	return status, err
 }
 
-// Update updates the current container with the provided resource spec
+// Update updates the current container with the provided resource spec.
+// The resources are passed to runc on stdin, no temporary file is written.
 func (r *Runc) Update(context context.Context, id string, resources *specs.LinuxResources) error {
-	buf := getBuf()
-	defer putBuf(buf)
-
-	if err := json.NewEncoder(buf).Encode(resources); err != nil {
+	data, err := r.marshal(resources)
+	if err != nil {
 		return err
 	}
 	args := []string{"update", "--resources=-", id}
-	cmd := r.command(context, args...)
-	cmd.Stdin = buf
-	return r.runOrError(cmd)
+	return r.retry(context, func() error {
+		cmd := r.command(context, args...)
+		cmd.Stdin = bytes.NewReader(data)
+		return r.runOrError(context, cmd)
+	})
 }
 
 // ErrParseRuncVersion is used when the runc version can't be parsed
 var ErrParseRuncVersion = errors.New("unable to parse runc version")
 
+// ErrUnsupportedByVersion is returned when runc fails because it is older
+// than the version introducing the requested feature
+type ErrUnsupportedByVersion struct {
+	// Feature is the flag or subcommand that is not supported
+	Feature string
+	// Have is the detected runc version
+	Have string
+	// Need is the minimum runc version supporting the feature
+	Need string
+	// Err is the error returned by runc
+	Err error
+}
+
+func (e *ErrUnsupportedByVersion) Error() string {
+	return fmt.Sprintf("%s is not supported by runc %s, upgrade to runc %s or newer: %s", e.Feature, e.Have, e.Need, e.Err)
+}
+
+func (e *ErrUnsupportedByVersion) Unwrap() error {
+	return e.Err
+}
+
 // Version represents the runc version information
 type Version struct {
 	Runc   string
 	Commit string
 	Spec   string
+	// Go is the version of Go runc was built with, if reported
+	Go string
+	// Libseccomp is the version of libseccomp runc is linked with, if reported
+	Libseccomp string
 }
 
 // Version returns the runc and runtime-spec versions
 func (r *Runc) Version(context context.Context) (Version, error) {
-	data, err := r.cmdOutput(r.command(context, "--version"), false, nil)
+	data, err := r.cmdOutput(context, r.command(context, "--version"), false, nil)
 	defer putBuf(data)
 	if err != nil {
 		return Version{}, err
@@ -723,25 +2451,35 @@ func (r *Runc) Version(context context.Context) (Version, error) {
 	return parseVersion(data.Bytes())
 }
 
+// parseVersion parses the output of `runc --version`. Lines may come in any
+// order and unknown lines are ignored, but the output of a runtime not
+// reporting a "runc version" line is ignored as a whole.
 func parseVersion(data []byte) (Version, error) {
 	var v Version
-	parts := strings.Split(strings.TrimSpace(string(data)), "\n")
-
-	if len(parts) > 0 {
-		if !strings.HasPrefix(parts[0], "runc version ") {
-			return v, nil
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if runc, ok := strings.CutPrefix(line, "runc version "); ok {
+			v.Runc = runc
+			continue
 		}
-		v.Runc = parts[0][13:]
-
-		for _, part := range parts[1:] {
-			if strings.HasPrefix(part, "commit: ") {
-				v.Commit = part[8:]
-			} else if strings.HasPrefix(part, "spec: ") {
-				v.Spec = part[6:]
-			}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "commit":
+			v.Commit = value
+		case "spec":
+			v.Spec = value
+		case "go":
+			v.Go = value
+		case "libseccomp":
+			v.Libseccomp = value
 		}
 	}
-
+	if v.Runc == "" {
+		return Version{}, nil
+	}
 	return v, nil
 }
 
@@ -752,19 +2490,36 @@ func parseVersion(data []byte) (Version, error) {
 //   - runc:  supported since runc v1.1.0
 //   - crun:  https://github.com/containers/crun/issues/1177
 //   - youki: https://github.com/containers/youki/issues/815
+//
+// With an older runc, the returned error is an *ErrUnsupportedByVersion.
 func (r *Runc) Features(context context.Context) (*features.Features, error) {
-	data, err := r.cmdOutput(r.command(context, "features"), false, nil)
+	data, err := r.cmdOutput(context, r.command(context, "features"), false, nil)
 	defer putBuf(data)
 	if err != nil {
-		return nil, err
+		return nil, r.unsupportedByVersion(context, err, "features", "1.1.0")
 	}
 	var feat features.Features
-	if err := json.Unmarshal(data.Bytes(), &feat); err != nil {
+	if err := r.unmarshal(data.Bytes(), &feat); err != nil {
 		return nil, err
 	}
 	return &feat, nil
 }
 
+// Argv returns the exact argv that is executed for the runc subcommand and
+// arguments, starting with the runc binary and followed by the global flags.
+//
+// Commands are always executed directly, never through a shell: every
+// element is passed to runc as a single argument, exactly as it appears
+// here, so shell metacharacters in user-controlled values like container
+// ids are never interpreted.
+func (r *Runc) Argv(args ...string) []string {
+	command := r.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+	return append(append([]string{command}, r.args()...), args...)
+}
+
 func (r *Runc) args() (out []string) {
 	if r.Root != "" {
 		out = append(out, "--root", r.Root)
@@ -774,14 +2529,20 @@ func (r *Runc) args() (out []string) {
 	}
 	if r.Log != "" {
 		out = append(out, "--log", r.Log)
+		if r.Debug && r.Flavor == FlavorRunsc {
+			// runsc only writes debug messages to the debug log
+			out = append(out, "--debug-log", r.Log)
+		}
 	}
 	if r.LogFormat != none {
 		out = append(out, "--log-format", string(r.LogFormat))
 	}
-	if r.SystemdCgroup {
+	if r.SystemdCgroup || (r.SystemdCgroupAuto && r.detectSystemd()) {
 		out = append(out, "--systemd-cgroup")
 	}
-	if r.Rootless != nil {
+	// runsc's --rootless has a different meaning and kata-runtime has no
+	// rootless mode, so the flag is only passed to runc
+	if r.Rootless != nil && (r.Flavor == "" || r.Flavor == FlavorRunc) {
 		// nil stands for "auto" (differs from explicit "false")
 		out = append(out, "--rootless="+strconv.FormatBool(*r.Rootless))
 	}
@@ -791,53 +2552,207 @@ func (r *Runc) args() (out []string) {
 	return out
 }
 
+// detectSystemd returns whether the host uses systemd, caching the result
+func (r *Runc) detectSystemd() bool {
+	st := r.state()
+	st.systemdOnce.Do(func() {
+		st.systemdDetected = isRunningSystemd()
+	})
+	return st.systemdDetected
+}
+
+// cachedVersion returns the version of runc, querying it until it has been
+// read successfully once for the lifetime of the Runc
+func (r *Runc) cachedVersion(context context.Context) (Version, error) {
+	st := r.state()
+	st.versionMu.Lock()
+	defer st.versionMu.Unlock()
+	if st.version != nil {
+		return *st.version, nil
+	}
+	v, err := r.Version(context)
+	if err != nil {
+		return Version{}, err
+	}
+	st.version = &v
+	return v, nil
+}
+
+// unsupportedByVersion turns err into an *ErrUnsupportedByVersion when the
+// runc version is older than need, the version introducing the feature.
+// Otherwise, or when the version is unknown, err is returned as is.
+func (r *Runc) unsupportedByVersion(context context.Context, err error, feature, need string) error {
+	v, verr := r.cachedVersion(context)
+	if verr != nil || v.Runc == "" || compareVersions(v.Runc, need) >= 0 {
+		return err
+	}
+	return &ErrUnsupportedByVersion{
+		Feature: feature,
+		Have:    v.Runc,
+		Need:    need,
+		Err:     err,
+	}
+}
+
 // runOrError will run the provided command.  If an error is
-// encountered and neither Stdout or Stderr was set the error and the
-// stderr of the command will be returned in the format of <error>:
-// <stderr>
-func (r *Runc) runOrError(cmd *exec.Cmd) error {
+// encountered, a *CommandError is returned, holding the separate stdout and
+// stderr of the command if neither Stdout or Stderr was set.
+func (r *Runc) runOrError(context context.Context, cmd *exec.Cmd) error {
 	if cmd.Stdout != nil || cmd.Stderr != nil {
-		ec, err := r.startCommand(cmd)
+		ec, err := r.startCommand(context, cmd, shortCommand)
 		if err != nil {
-			return err
+			return r.commandError(cmd, err)
 		}
-		status, err := Monitor.Wait(cmd, ec)
+		status, err := r.wait(cmd, ec)
+		return r.exitError(cmd, status, err)
+	}
+	stdout, stderr := getBuf(), getBuf()
+	defer putBuf(stdout)
+	defer putBuf(stderr)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	ec, err := r.startCommand(context, cmd, shortCommand)
+	if err == nil {
+		var status int
+		status, err = r.wait(cmd, ec)
 		if err == nil && status != 0 {
 			err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
 		}
-		return err
 	}
-	data, err := r.cmdOutput(cmd, true, nil)
-	defer putBuf(data)
 	if err != nil {
-		return fmt.Errorf("%s: %s", err, data.String())
+		cerr := r.commandError(cmd, err)
+		cerr.Stdout = r.errorOutput(stdout.Bytes())
+		cerr.Stderr = r.errorOutput(stderr.Bytes())
+		return cerr
+	}
+	return nil
+}
+
+// exitError returns the error of cmd which exited with status, or failed to
+// be waited on with err, as a *CommandError naming its subcommand
+func (r *Runc) exitError(cmd *exec.Cmd, status int, err error) error {
+	if err == nil && status != 0 {
+		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
+	}
+	if err != nil {
+		return r.commandError(cmd, err)
 	}
 	return nil
 }
 
+// commandError returns the *CommandError of cmd failing with err, naming
+// its subcommand and sanitized args
+func (r *Runc) commandError(cmd *exec.Cmd, err error) *CommandError {
+	cerr := &CommandError{
+		Command: r.subcommand(cmd),
+		Err:     err,
+	}
+	if i := 2 + len(r.args()); i < len(cmd.Args) {
+		cerr.Args = sanitizeArgs(cmd.Args[i:])
+	}
+	return cerr
+}
+
+// sanitizeArgs returns a copy of the args with the values of the environment
+// variables passed with --env, as ExtraArgs may, redacted
+func sanitizeArgs(args []string) []string {
+	out := make([]string, len(args))
+	redact := false
+	for i, arg := range args {
+		switch {
+		case redact:
+			arg, redact = redactEnv(arg), false
+		case arg == "--env" || arg == "-e":
+			redact = true
+		case strings.HasPrefix(arg, "--env="):
+			arg = "--env=" + redactEnv(strings.TrimPrefix(arg, "--env="))
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// redactEnv redacts the value of the KEY=VALUE environment variable
+func redactEnv(env string) string {
+	if key, _, ok := strings.Cut(env, "="); ok {
+		return key + "=<redacted>"
+	}
+	return env
+}
+
+// errorOutput returns a copy of the output of a failed command to attach to
+// its error, keeping the tail of the output beyond MaxErrorOutput
+func (r *Runc) errorOutput(data []byte) []byte {
+	if r.MaxErrorOutput <= 0 || len(data) <= r.MaxErrorOutput {
+		return append([]byte(nil), data...)
+	}
+	out := []byte(fmt.Sprintf("[%d bytes truncated]...", len(data)-r.MaxErrorOutput))
+	return append(out, data[len(data)-r.MaxErrorOutput:]...)
+}
+
+// CommandError is returned when a runc command fails, with the output of
+// the command. The commands whose output is parsed, like state or ps, or
+// sent to an IO leave Stdout and Stderr empty, the output being added to
+// the error wrapping the CommandError if at all.
+type CommandError struct {
+	// Command is the runc subcommand which failed, like "start"
+	Command string
+	// Args are the arguments of the subcommand, without the global flags
+	// and with the values of environment variables redacted
+	Args   []string
+	Err    error
+	Stdout []byte
+	Stderr []byte
+}
+
+func (e *CommandError) Error() string {
+	out := strings.TrimSpace(string(e.Stderr))
+	if stdout := strings.TrimSpace(string(e.Stdout)); stdout != "" {
+		out = strings.TrimSpace(stdout + "\n" + out)
+	}
+	if e.Command != "" {
+		if out == "" {
+			return fmt.Sprintf("runc %s: %s", e.Command, e.Err)
+		}
+		return fmt.Sprintf("runc %s: %s: %s", e.Command, e.Err, out)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, out)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
 // callers of cmdOutput are expected to call putBuf on the returned Buffer
 // to ensure it is released back to the shared pool after use.
-func (r *Runc) cmdOutput(cmd *exec.Cmd, combined bool, started chan<- int) (*bytes.Buffer, error) {
+func (r *Runc) cmdOutput(context context.Context, cmd *exec.Cmd, combined bool, started chan<- int) (*bytes.Buffer, error) {
+	b, _, err := r.cmdOutputLimit(context, cmd, combined, started, 0, shortCommand)
+	return b, err
+}
+
+// cmdOutputLimit is like cmdOutput but captures at most limit bytes of
+// output when limit is positive, reporting whether output was discarded
+func (r *Runc) cmdOutputLimit(context context.Context, cmd *exec.Cmd, combined bool, started chan<- int, limit int, kind commandKind) (*bytes.Buffer, bool, error) {
 	b := getBuf()
 
-	cmd.Stdout = b
+	var w io.Writer = b
+	lw := &limitWriter{w: b, n: limit}
+	if limit > 0 {
+		w = lw
+	}
+	cmd.Stdout = w
 	if combined {
-		cmd.Stderr = b
+		cmd.Stderr = w
 	}
-	ec, err := r.startCommand(cmd)
+	ec, err := r.startCommand(context, cmd, kind)
 	if err != nil {
-		return nil, err
+		return b, false, r.commandError(cmd, err)
 	}
 	if started != nil {
 		started <- cmd.Process.Pid
 	}
 
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate successfully: %w", cmd.Args[0], &ExitError{status})
-	}
-
-	return b, err
+	status, err := r.wait(cmd, ec)
+	return b, lw.truncated, r.exitError(cmd, status, err)
 }
 
 // ExitError holds the status return code when a process exits with an error code
@@ -17,16 +17,24 @@
 package runc
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
 	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
 func TestParseVersion(t *testing.T) {
@@ -76,6 +84,42 @@ spec: 1.0.1-dev
 		testParseVersion(t, input, expected)
 	})
 
+	t.Run("WithGoAndLibseccomp", func(t *testing.T) {
+		input := `runc version 1.1.4
+commit: v1.1.4-0-g5fd4c4d1
+spec: 1.0.2-dev
+go: go1.17.10
+libseccomp: 2.5.4
+`
+		expected := Version{
+			Runc:       "1.1.4",
+			Commit:     "v1.1.4-0-g5fd4c4d1",
+			Spec:       "1.0.2-dev",
+			Go:         "go1.17.10",
+			Libseccomp: "2.5.4",
+		}
+		testParseVersion(t, input, expected)
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		input := `WARNING: unknown build
+go: go1.20.4
+libseccomp: 2.5.4
+runc version 1.1.7
+built: yesterday
+spec: 1.0.2-dev
+commit: v1.1.7-0-g860f061b
+`
+		expected := Version{
+			Runc:       "1.1.7",
+			Commit:     "v1.1.7-0-g860f061b",
+			Spec:       "1.0.2-dev",
+			Go:         "go1.20.4",
+			Libseccomp: "2.5.4",
+		}
+		testParseVersion(t, input, expected)
+	})
+
 	t.Run("Garbage", func(t *testing.T) {
 		input := `Garbage
 spec: nope
@@ -89,6 +133,24 @@ spec: nope
 	})
 }
 
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.2-dev", "1.0.2", 0},
+		{"1.0.0-rc93", "1.1.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"v1.1", "1.1.0", 0},
+		{"1.2.0", "1.1.0", 1},
+	} {
+		if actual := compareVersions(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("compareVersions(%q, %q): expected %d, actual %d", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
 func TestParallelCmds(t *testing.T) {
 	rc := &Runc{
 		// we don't need a real runc, we just want to test running a caller of cmdOutput in parallel
@@ -117,7 +179,7 @@ func TestRuncRunExit(t *testing.T) {
 		Command: "/bin/true",
 	}
 
-	status, err := okRunc.Run(ctx, "fake-id", "fake-bundle", &CreateOpts{})
+	status, err := okRunc.Run(ctx, "fake-id", newTestBundle(t, nil), &CreateOpts{})
 	if err != nil {
 		t.Fatalf("Unexpected error from Run: %s", err)
 	}
@@ -129,7 +191,7 @@ func TestRuncRunExit(t *testing.T) {
 		Command: "/bin/false",
 	}
 
-	status, err = failRunc.Run(ctx, "fake-id", "fake-bundle", &CreateOpts{})
+	status, err = failRunc.Run(ctx, "fake-id", newTestBundle(t, nil), &CreateOpts{})
 	if err == nil {
 		t.Fatal("Expected error from Run, but got nil")
 	}
@@ -207,7 +269,7 @@ func TestRuncStarted(t *testing.T) {
 		defer wg.Done()
 		interrupt(ctx, t, started)
 	}()
-	status, err := sleepRunc.Run(ctx, "fake-id", "fake-bundle", &CreateOpts{
+	status, err := sleepRunc.Run(ctx, "fake-id", newTestBundle(t, nil), &CreateOpts{
 		Started: started,
 	})
 	if err == nil {
@@ -356,3 +418,2338 @@ func TestRuncFeatures(t *testing.T) {
 		t.Fatalf("\"rro\" was not found in feat.MountOptions (feat=%+v)", feat)
 	}
 }
+
+// newDummyRunc writes a shell script with the provided body to a temporary
+// directory and returns its path, to be used in place of runc for testing.
+func newDummyRunc(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runc")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRuncDeleteForceRetry(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "attempted")
+	busyRunc := &Runc{
+		Command: newDummyRunc(t, `
+if [ ! -e `+marker+` ]; then
+	touch `+marker+`
+	echo "unable to destroy container: remove /sys/fs/cgroup/fake-id: device or resource busy" >&2
+	exit 1
+fi
+`),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := busyRunc.Delete(ctx, "fake-id", &DeleteOpts{Force: true}); err != nil {
+		t.Fatalf("Unexpected error from forced Delete: %s", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected the first delete attempt to fail with EBUSY: %s", err)
+	}
+
+	// a RetryPolicy of the Runc doesn't keep EBUSY from being retried
+	os.Remove(marker)
+	busyRunc.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 2,
+		Retryable: func(err error) bool {
+			return strings.Contains(err.Error(), "temporarily unavailable")
+		},
+	}
+	if err := busyRunc.Delete(ctx, "fake-id", &DeleteOpts{Force: true}); err != nil {
+		t.Fatalf("Unexpected error from forced Delete with a RetryPolicy: %s", err)
+	}
+
+	os.Remove(marker)
+	if err := busyRunc.Delete(ctx, "fake-id", &DeleteOpts{}); err == nil {
+		t.Fatal("Expected error from non-forced Delete, but got nil")
+	}
+}
+
+func TestRuncExecStdinClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	catRunc := &Runc{
+		Command: newDummyRunc(t, "exec cat\n"),
+	}
+	io, err := NewPipeIO(os.Getuid(), os.Getgid(), func(o *IOOption) {
+		o.StdinClose = true
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from NewPipeIO: %s", err)
+	}
+	defer io.Close()
+
+	if err := catRunc.Exec(ctx, "fake-id", specs.Process{}, &ExecOpts{IO: io}); err != nil {
+		t.Fatalf("Unexpected error from Exec: %s", err)
+	}
+}
+
+func TestRuncSystemdCgroupAuto(t *testing.T) {
+	defer func(dir string) { systemdRunDir = dir }(systemdRunDir)
+
+	hasSystemdCgroup := func(r *Runc) bool {
+		for _, a := range r.args() {
+			if a == "--systemd-cgroup" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// systemd host
+	systemdRunDir = t.TempDir()
+	r := &Runc{SystemdCgroupAuto: true}
+	if !hasSystemdCgroup(r) {
+		t.Fatal("Expected --systemd-cgroup on a systemd host")
+	}
+	// the detection is cached for the lifetime of the Runc
+	systemdRunDir = filepath.Join(t.TempDir(), "missing")
+	if !hasSystemdCgroup(r) {
+		t.Fatal("Expected the systemd detection to be cached")
+	}
+
+	// cgroupfs host
+	r = &Runc{SystemdCgroupAuto: true}
+	if hasSystemdCgroup(r) {
+		t.Fatal("Unexpected --systemd-cgroup on a cgroupfs host")
+	}
+	if r := (&Runc{}); hasSystemdCgroup(r) {
+		t.Fatal("Unexpected --systemd-cgroup without auto-detection")
+	}
+}
+
+func TestRuncRunOutput(t *testing.T) {
+	ctx := context.Background()
+	failRunc := &Runc{
+		Command: newDummyRunc(t, "echo 'container init failed' >&2\nexit 3\n"),
+	}
+	status, err := failRunc.Run(ctx, "fake-id", newTestBundle(t, nil), &CreateOpts{})
+	if err == nil {
+		t.Fatal("Expected error from Run, but got nil")
+	}
+	if status != 3 {
+		t.Fatalf("Expected exit status 3 from Run, got %d", status)
+	}
+	if extracted := extractStatus(err); extracted != status {
+		t.Fatalf("Expected extracted exit status %d from Run, got %d", status, extracted)
+	}
+	if !strings.Contains(err.Error(), "container init failed") {
+		t.Fatalf("Expected stderr in the error from Run, got %q", err)
+	}
+}
+
+func TestRuncShutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dummyCommand, err := dummySleepRunc()
+	if err != nil {
+		t.Fatalf("Failed to create dummy sleep runc: %s", err)
+	}
+	defer os.Remove(dummyCommand)
+	sleepRunc := &Runc{
+		Command: dummyCommand,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sleepRunc.Start(ctx, "fake-id")
+	}()
+	// wait for the command to be tracked
+	st := sleepRunc.state()
+	for {
+		st.mu.Lock()
+		n := len(st.procs)
+		st.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sleepRunc.Shutdown(); err != nil {
+		t.Fatalf("Unexpected error from Shutdown: %s", err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("Expected error from Start, but got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Start to be terminated by Shutdown")
+	}
+	if err := sleepRunc.Start(ctx, "fake-id"); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("Expected ErrShutdown from Start after Shutdown, got %v", err)
+	}
+}
+
+func TestRuncTimeout(t *testing.T) {
+	dummyCommand, err := dummySleepRunc()
+	if err != nil {
+		t.Fatalf("Failed to create dummy sleep runc: %s", err)
+	}
+	defer os.Remove(dummyCommand)
+
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		ctx     time.Duration
+		// exceeded is whether the error must be a context.DeadlineExceeded
+		exceeded bool
+	}{
+		{"RuncTimeout", 100 * time.Millisecond, 10 * time.Second, true},
+		{"ContextDeadline", time.Hour, 100 * time.Millisecond, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.ctx)
+			defer cancel()
+			sleepRunc := &Runc{
+				Command: dummyCommand,
+				Timeout: tc.timeout,
+			}
+			start := time.Now()
+			err := sleepRunc.Start(ctx, "fake-id")
+			if err == nil {
+				t.Fatal("Expected error from Start, but got nil")
+			}
+			if tc.exceeded && !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Expected context.DeadlineExceeded from Start, but got %v", err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Fatalf("Expected the command to be killed at the timeout, but it ran for %s", d)
+			}
+		})
+	}
+}
+
+func TestRuncTimeoutLongCommand(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "exec sleep 0.5\n"),
+		Timeout: 100 * time.Millisecond,
+	}
+	// the foreground run lasts as long as the container
+	if status, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), nil); err != nil || status != 0 {
+		t.Fatalf("expected the foreground run not to be killed at the timeout, got %d, %v", status, err)
+	}
+	if _, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{Detach: true}); err == nil {
+		t.Fatal("expected the detached run to be killed at the timeout")
+	}
+}
+
+func TestRuncDetachedOutput(t *testing.T) {
+	// the process, left running by runc, inherits its stdio
+	r := &Runc{
+		Command: newDummyRunc(t, `
+sleep 3 &
+`),
+	}
+	start := time.Now()
+	if _, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{Detach: true}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected the detached run to return once runc has exited, took %s", d)
+	}
+
+	// the error runc writes to stderr is still reported
+	r.Command = newDummyRunc(t, `
+sleep 3 &
+echo 'container init failed' >&2
+exit 1
+`)
+	start = time.Now()
+	_, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{Detach: true})
+	if err == nil || !strings.Contains(err.Error(), "container init failed") {
+		t.Fatalf("expected stderr in the error of the detached run, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected the failed detached run to return once runc has exited, took %s", d)
+	}
+}
+
+func TestRuncRunOutputLimit(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "head -c 2000000 /dev/zero\nexit 1\n"),
+	}
+	_, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	if !errors.Is(err, ErrOutputTruncated) {
+		t.Fatalf("expected the output of the run to be capped, got %v", err)
+	}
+}
+
+func TestRuncCheckpointStatusFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	statusRunc := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--status-fd" ]; then
+		printf '\0' >&"$arg"
+	fi
+	prev=$arg
+done
+`),
+	}
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	err = statusRunc.Checkpoint(ctx, "fake-id", &CheckpointOpts{
+		LazyPages:  true,
+		StatusFile: wr,
+	})
+	wr.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error from Checkpoint: %s", err)
+	}
+	status, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(status) != "\x00" {
+		t.Fatalf("Expected a \\0 status event, got %q", status)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/usr/bin:/bin", "HOME=/root", "TERM=xterm"}
+	extra := []string{"HOME=/home/user", "LANG=C.UTF-8"}
+	expected := []string{"PATH=/usr/bin:/bin", "HOME=/home/user", "TERM=xterm", "LANG=C.UTF-8"}
+	if actual := mergeEnv(base, extra); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %q but got %q", expected, actual)
+	}
+}
+
+func TestRuncExecEnv(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "process.json")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--process" ]; then
+		cp "$arg" `+out+`
+	fi
+	prev=$arg
+done
+`),
+	}
+	err := r.Exec(context.Background(), "fake-id", specs.Process{
+		Env: []string{"PATH=/bin", "FOO=spec"},
+	}, &ExecOpts{
+		Env: []string{"FOO=opts", "BAR=opts"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from Exec: %s", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p specs.Process
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"PATH=/bin", "FOO=opts", "BAR=opts"}; !reflect.DeepEqual(p.Env, expected) {
+		t.Fatalf("expected env %q but got %q", expected, p.Env)
+	}
+}
+
+type testConsoleSocket string
+
+func (s testConsoleSocket) Path() string {
+	return string(s)
+}
+
+func TestRuncCreateEx(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--pid-file" ]; then
+		printf 4242 > "$arg"
+	fi
+	prev=$arg
+done
+`),
+	}
+	bundle := newTestBundle(t, nil)
+	result, err := r.CreateEx(context.Background(), "fake-id", bundle, &CreateOpts{
+		ConsoleSocket: testConsoleSocket("/run/console.sock"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateEx: %s", err)
+	}
+	hash, err := BundleHash(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CreateResult{Pid: 4242, ConsoleSocket: "/run/console.sock", ConfigHash: hash}
+	if *result != expected {
+		t.Fatalf("expected %+v but got %+v", expected, *result)
+	}
+}
+
+func TestRuncFlavorArgs(t *testing.T) {
+	rootless := true
+	for _, tc := range []struct {
+		flavor   Flavor
+		expected []string
+	}{
+		{"", []string{"--debug", "--log", "/run/log.json", "--rootless=true"}},
+		{FlavorRunc, []string{"--debug", "--log", "/run/log.json", "--rootless=true"}},
+		{FlavorRunsc, []string{"--debug", "--log", "/run/log.json", "--debug-log", "/run/log.json"}},
+		{FlavorKata, []string{"--debug", "--log", "/run/log.json"}},
+	} {
+		r := &Runc{
+			Debug:    true,
+			Log:      "/run/log.json",
+			Rootless: &rootless,
+			Flavor:   tc.flavor,
+		}
+		if actual := r.args(); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("flavor %q: expected args %q but got %q", tc.flavor, tc.expected, actual)
+		}
+	}
+}
+
+func TestRuncListByPrefix(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `echo '[{"id":"ns1-web","status":"running"},{"id":"ns2-web","status":"running"},{"id":"ns1-db","status":"stopped"}]'`),
+	}
+	containers, err := r.ListByPrefix(context.Background(), "ns1-")
+	if err != nil {
+		t.Fatalf("Unexpected error from ListByPrefix: %s", err)
+	}
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	if expected := []string{"ns1-web", "ns1-db"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected ids %q but got %q", expected, ids)
+	}
+}
+
+func TestRuncExecMaxOutputBytes(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "i=0; while [ $i -lt 100 ]; do printf 0123456789; i=$((i+1)); done; exit 1\n"),
+	}
+	err := r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{
+		MaxOutputBytes: 25,
+	})
+	if err == nil {
+		t.Fatal("Expected error from Exec, but got nil")
+	}
+	if !errors.Is(err, ErrOutputTruncated) {
+		t.Fatalf("Expected truncated output to be reported, got %v", err)
+	}
+	if status := extractStatus(err); status != 1 {
+		t.Fatalf("Expected exit status 1 from Exec, got %d", status)
+	}
+	if !strings.Contains(err.Error(), ": 0123456789012345678901234: output truncated") {
+		t.Fatalf("Expected output to be truncated to 25 bytes, got %q", err)
+	}
+
+	err = r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{
+		MaxOutputBytes: 2000,
+	})
+	if errors.Is(err, ErrOutputTruncated) {
+		t.Fatalf("Unexpected truncation of output within the limit: %v", err)
+	}
+}
+
+func TestRuncStates(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for id; do :; done
+case "$id" in
+bad-*)
+	echo "container does not exist" >&2
+	exit 1
+	;;
+esac
+echo '{"id":"'$id'","pid":1234,"status":"running"}'
+`),
+	}
+	states, err := r.States(context.Background(), []string{"ok-1", "bad-1", "ok-2", "ok-3", "bad-2"})
+	if err == nil {
+		t.Fatal("Expected error from States, but got nil")
+	}
+	for _, id := range []string{"bad-1", "bad-2"} {
+		if !strings.Contains(err.Error(), id+": ") {
+			t.Errorf("Expected the error of %s in %q", id, err)
+		}
+	}
+	if len(states) != 3 {
+		t.Fatalf("Expected 3 states, got %d", len(states))
+	}
+	for _, id := range []string{"ok-1", "ok-2", "ok-3"} {
+		if c := states[id]; c == nil || c.ID != id || c.Status != "running" {
+			t.Errorf("Unexpected state for %s: %+v", id, c)
+		}
+	}
+}
+
+// newArgvRunc returns the path of a runc stub recording its arguments, and
+// a function returning the arguments of the last invocation
+func newArgvRunc(t *testing.T) (string, func() []string) {
+	out := filepath.Join(t.TempDir(), "argv")
+	command := newDummyRunc(t, "printf '%s\\0' \"$@\" > "+out+"\n")
+	return command, func() []string {
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+	}
+}
+
+func TestRuncArgvNoShell(t *testing.T) {
+	command, argv := newArgvRunc(t)
+	r := &Runc{
+		Command: command,
+		Root:    "/run/runc $(id)",
+	}
+	for _, id := range []string{"; rm -rf /", "$(reboot)", "`id`", "a b\tc\nd", "'\"\\*?"} {
+		if err := r.Kill(context.Background(), id, int(syscall.SIGKILL), &KillOpts{All: true}); err != nil {
+			t.Fatalf("kill %q: %s", id, err)
+		}
+		actual := argv()
+		expected := r.Argv("kill", "--all", id, "9")[1:]
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected argv %q but got %q", expected, actual)
+		}
+		if actual[len(actual)-2] != id {
+			t.Fatalf("expected id %q to be passed as a single argument, got %q", id, actual)
+		}
+	}
+}
+
+func TestRuncUpdateStdin(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "resources.json")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+[ "$1" = "update" ] && [ "$2" = "--resources=-" ] || exit 1
+cat > `+out+`
+`),
+	}
+	resources := &specs.LinuxResources{Pids: &specs.LinuxPids{Limit: 100}}
+	if err := r.Update(context.Background(), "fake-id", resources); err != nil {
+		t.Fatalf("Unexpected error from Update: %s", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual specs.LinuxResources
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("expected resources JSON on stdin, got %q: %s", data, err)
+	}
+	if !reflect.DeepEqual(&actual, resources) {
+		t.Fatalf("expected resources %+v but got %+v", resources, actual)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	c, err := parseState([]byte(`{
+  "ociVersion": "1.0.2-dev",
+  "id": "fake-id",
+  "pid": 4242,
+  "status": "running",
+  "bundle": "/run/bundle",
+  "rootfs": "/run/bundle/rootfs",
+  "created": "2023-01-02T03:04:05.000000006Z",
+  "annotations": {"io.example": "value"},
+  "owner": ""
+}`), json.Unmarshal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &Container{
+		ID:          "fake-id",
+		Pid:         4242,
+		Status:      "running",
+		Bundle:      "/run/bundle",
+		Rootfs:      "/run/bundle/rootfs",
+		Created:     time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC),
+		Annotations: map[string]string{"io.example": "value"},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Fatalf("expected: %+v, actual: %+v", expected, c)
+	}
+
+	if _, err := parseState([]byte("container fake-id does not exist"), json.Unmarshal); err == nil {
+		t.Fatal("expected error parsing invalid state")
+	}
+}
+
+func TestRuncBundle(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `echo '{"ociVersion":"1.0.2","id":"fake-id","pid":4242,"status":"running","bundle":"/run/containerd/fake-id","rootfs":"/run/containerd/fake-id/rootfs"}'`),
+	}
+	bundle, err := r.Bundle(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle != "/run/containerd/fake-id" {
+		t.Fatalf("expected bundle /run/containerd/fake-id, got %q", bundle)
+	}
+}
+
+func TestRuncOwner(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `echo '{"ociVersion":"1.0.2","id":"fake-id","pid":4242,"status":"running","bundle":"/run/bundle","owner":"alice"}'`),
+	}
+	owner, err := r.Owner(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "alice" {
+		t.Fatalf("expected owner alice, got %q", owner)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	containers, err := parseList([]byte(`[
+  {"ociVersion": "1.0.2-dev", "id": "c1", "pid": 1, "status": "running", "bundle": "/run/c1", "rootfs": "/run/c1/rootfs", "created": "2023-01-02T03:04:05Z", "owner": "root"},
+  {"ociVersion": "1.0.2-dev", "id": "c2", "pid": 0, "status": "stopped", "bundle": "/run/c2", "rootfs": "/run/c2/rootfs", "created": "2023-01-02T03:04:05Z", "owner": "root"}
+]`), json.Unmarshal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(containers) != 2 || containers[0].ID != "c1" || containers[1].Status != "stopped" {
+		t.Fatalf("unexpected containers %+v", containers)
+	}
+
+	// runc prints null when there are no containers
+	containers, err = parseList([]byte("null\n"), json.Unmarshal)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(containers) != 0 {
+		t.Fatalf("expected no containers, got %+v", containers)
+	}
+}
+
+func TestRuncEventsBufferSize(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo '{"type":"stats","id":"fake-id","data":{"pids":{"current":1}}}'
+echo '{"type":"oom","id":"fake-id"}'
+`),
+	}
+	c, err := r.EventsWithOpts(context.Background(), "fake-id", &EventsOpts{Interval: time.Second, BufferSize: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error from EventsWithOpts: %s", err)
+	}
+	if cap(c) != 1 {
+		t.Fatalf("expected a buffer of 1 event, got %d", cap(c))
+	}
+	var types []string
+	for e := range c {
+		types = append(types, e.Type)
+	}
+	if expected := []string{EventTypeStats, EventTypeOOM}; !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected events %q but got %q", expected, types)
+	}
+
+	c, err = r.Events(context.Background(), "fake-id", time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error from Events: %s", err)
+	}
+	if cap(c) != defaultEventsBufferSize {
+		t.Fatalf("expected a buffer of %d events, got %d", defaultEventsBufferSize, cap(c))
+	}
+	for range c {
+	}
+}
+
+func TestRuncRestoreNewID(t *testing.T) {
+	command, argv := newArgvRunc(t)
+	r := &Runc{
+		Command: command,
+	}
+	status, err := r.Restore(context.Background(), "new-id", "/run/new-bundle", &RestoreOpts{
+		CheckpointOpts: CheckpointOpts{
+			ImagePath: "/var/lib/checkpoints/old-id",
+		},
+		Detach: true,
+	})
+	if err != nil || status != 0 {
+		t.Fatalf("Unexpected error from Restore: %d, %v", status, err)
+	}
+	actual := argv()
+	expected := []string{"restore", "--image-path", "/var/lib/checkpoints/old-id", "--detach", "--bundle", "/run/new-bundle", "new-id"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+}
+
+func TestRuncCheckpointTCP(t *testing.T) {
+	command, argv := newArgvRunc(t)
+	r := &Runc{
+		Command: command,
+	}
+	if err := r.Checkpoint(context.Background(), "fake-id", &CheckpointOpts{
+		ImagePath:       "/var/lib/checkpoints/fake-id",
+		AllowOpenTCP:    true,
+		TCPSkipInFlight: true,
+	}); err != nil {
+		t.Fatalf("Unexpected error from Checkpoint: %v", err)
+	}
+	expected := []string{"checkpoint", "--image-path", "/var/lib/checkpoints/fake-id", "--tcp-established", "--tcp-skip-in-flight", "fake-id"}
+	if actual := argv(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+
+	if _, err := r.Restore(context.Background(), "fake-id", "/run/bundle", &RestoreOpts{
+		CheckpointOpts: CheckpointOpts{
+			ImagePath:       "/var/lib/checkpoints/fake-id",
+			AllowOpenTCP:    true,
+			TCPSkipInFlight: true,
+		},
+	}); err != nil {
+		t.Fatalf("Unexpected error from Restore: %v", err)
+	}
+	expected = []string{"restore", "--image-path", "/var/lib/checkpoints/fake-id", "--tcp-established", "--bundle", "/run/bundle", "fake-id"}
+	if actual := argv(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+}
+
+func TestRuncCheckpointCriuLog(t *testing.T) {
+	workDir := t.TempDir()
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--work-path" ]; then
+		echo "(00.001) Dumping the container" > "$arg/dump.log"
+		echo "(00.002) Error (criu/cr-dump.c:1234): Can't dump the task" >> "$arg/dump.log"
+	fi
+	prev=$arg
+done
+echo "criu failed: type NOTIFY errno 0" >&2
+exit 1
+`),
+	}
+	err := r.Checkpoint(context.Background(), "fake-id", &CheckpointOpts{
+		ImagePath: t.TempDir(),
+		WorkDir:   workDir,
+	})
+	if err == nil {
+		t.Fatal("Expected Checkpoint to fail")
+	}
+	for _, expected := range []string{"criu failed", filepath.Join(workDir, "dump.log"), "Can't dump the task"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected %q in the error, got %s", expected, err)
+		}
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Expected the runc error to be wrapped, got %v", err)
+	}
+}
+
+func TestRuncRunDetached(t *testing.T) {
+	exited := filepath.Join(t.TempDir(), "exited")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+run)
+	for arg; do [ "$arg" = "--detach" ] && exit 0; done
+	exit 1
+	;;
+state)
+	if [ -e `+exited+` ]; then
+		echo '{"id":"fake-id","pid":0,"status":"stopped"}'
+	else
+		echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	fi
+	;;
+events)
+	echo '{"type":"stats","id":"fake-id","data":{}}'
+	touch `+exited+`
+	;;
+esac
+`),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ec, err := r.RunDetached(ctx, "fake-id", newTestBundle(t, nil), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from RunDetached: %s", err)
+	}
+	select {
+	case e, ok := <-ec:
+		if !ok {
+			t.Fatal("expected an exit before the channel is closed")
+		}
+		if e.Pid != 4242 || e.Status != 255 {
+			t.Fatalf("unexpected exit %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the exit")
+	}
+}
+
+func TestRuncRunDetachedBackground(t *testing.T) {
+	// the container keeps running, with the stdio of runc, after it exits
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+run)
+	sleep 3 &
+	;;
+state)
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	;;
+events)
+	exec sleep 10
+	;;
+esac
+`),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	ec, err := r.RunDetached(ctx, "fake-id", newTestBundle(t, nil), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from RunDetached: %s", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected RunDetached to return once runc has exited, took %s", d)
+	}
+	cancel()
+	for range ec {
+	}
+}
+
+func TestRuncKillSignal(t *testing.T) {
+	command, argv := newArgvRunc(t)
+	r := &Runc{
+		Command: command,
+	}
+	var sig os.Signal = unix.SIGTERM
+	if err := r.KillSignal(context.Background(), "fake-id", sig, nil); err != nil {
+		t.Fatalf("Unexpected error from KillSignal: %s", err)
+	}
+	if actual, expected := argv(), []string{"kill", "fake-id", "15"}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+	if err := r.KillSignal(context.Background(), "fake-id", os.Interrupt, nil); err != nil {
+		t.Fatalf("Unexpected error from KillSignal: %s", err)
+	}
+	if actual, expected := argv(), []string{"kill", "fake-id", "2"}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+}
+
+func TestRuncCreateExitNotify(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	if [ "$prev" = "--preserve-fds" ] && [ "$arg" != "1" ]; then
+		exit 1
+	fi
+	prev=$arg
+done
+# stand in for the init process holding the exit fd
+(sleep 0.3; printf x >&3) >/dev/null 2>&1 &
+`),
+	}
+	// an unbuffered channel would block the notification forever
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{ExitNotify: make(chan struct{})}); err == nil {
+		t.Fatal("expected an error for an unbuffered ExitNotify")
+	}
+	exited := make(chan struct{}, 1)
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{ExitNotify: exited}); err != nil {
+		t.Fatalf("Unexpected error from Create: %s", err)
+	}
+	select {
+	case <-exited:
+		t.Fatal("expected no exit while the init process holds the exit fd")
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an exit once the init process wrote to the exit fd")
+	}
+}
+
+func TestRuncListIDs(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+[ "$1" = "list" ] && [ "$2" = "--quiet" ] || exit 1
+printf 'c1\nc2\n\nc3\n'
+`),
+	}
+	ids, err := r.ListIDs(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error from ListIDs: %s", err)
+	}
+	if expected := []string{"c1", "c2", "c3"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected ids %q but got %q", expected, ids)
+	}
+}
+
+func TestRuncRetryPolicy(t *testing.T) {
+	attempts := filepath.Join(t.TempDir(), "attempts")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo x >> `+attempts+`
+if [ $(wc -l < `+attempts+`) -lt 3 ]; then
+	echo "unable to freeze: resource temporarily unavailable" >&2
+	exit 1
+fi
+`),
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 5,
+			Backoff: func(n int) time.Duration {
+				return time.Duration(n) * time.Millisecond
+			},
+			Retryable: func(err error) bool {
+				return strings.Contains(err.Error(), "temporarily unavailable")
+			},
+		},
+	}
+	count := func() int {
+		data, err := os.ReadFile(attempts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+
+	if err := r.Pause(context.Background(), "fake-id"); err != nil {
+		t.Fatalf("Expected Pause to succeed after retries, got %s", err)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", n)
+	}
+
+	// errors which are not transient are not retried
+	os.Remove(attempts)
+	r.RetryPolicy.Retryable = func(error) bool { return false }
+	if err := r.Pause(context.Background(), "fake-id"); err == nil {
+		t.Fatal("Expected Pause to fail without retries")
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRuncRetryTransition(t *testing.T) {
+	paused := filepath.Join(t.TempDir(), "paused")
+	r := &Runc{
+		// the first attempt changes the status of the container but fails
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	if [ -e `+paused+` ]; then
+		echo '{"id":"fake-id","pid":4242,"status":"paused"}'
+	else
+		echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	fi
+	exit 0
+	;;
+pause)
+	if [ -e `+paused+` ]; then
+		echo "container not running" >&2
+		exit 1
+	fi
+	touch `+paused+`
+	;;
+resume)
+	if [ ! -e `+paused+` ]; then
+		echo "container not paused" >&2
+		exit 1
+	fi
+	rm `+paused+`
+	;;
+esac
+echo "unable to freeze: resource temporarily unavailable" >&2
+exit 1
+`),
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			Backoff: func(int) time.Duration {
+				return time.Millisecond
+			},
+			Retryable: func(err error) bool {
+				return strings.Contains(err.Error(), "temporarily unavailable")
+			},
+		},
+	}
+	if err := r.Pause(context.Background(), "fake-id"); err != nil {
+		t.Fatalf("expected the retried Pause to succeed, got %s", err)
+	}
+	if err := r.Resume(context.Background(), "fake-id"); err != nil {
+		t.Fatalf("expected the retried Resume to succeed, got %s", err)
+	}
+	// without a failed attempt, the container is not paused by the call
+	if err := r.Resume(context.Background(), "fake-id"); err == nil || !strings.Contains(err.Error(), "container not paused") {
+		t.Fatalf("expected Resume to fail for a running container, got %v", err)
+	}
+}
+
+func TestRuncMetrics(t *testing.T) {
+	type sample struct {
+		cmd string
+		d   time.Duration
+		err error
+	}
+	var (
+		mu      sync.Mutex
+		samples []sample
+	)
+	r := &Runc{
+		Command: newDummyRunc(t, `
+sleep 0.1
+[ "$3" = "start" ] || exit 3
+`),
+		Root: "/run/runc",
+		Metrics: func(cmd string, d time.Duration, err error) {
+			mu.Lock()
+			samples = append(samples, sample{cmd, d, err})
+			mu.Unlock()
+		},
+	}
+	if err := r.Start(context.Background(), "fake-id"); err != nil {
+		t.Fatalf("Unexpected error from Start: %s", err)
+	}
+	if err := r.Pause(context.Background(), "fake-id"); err == nil {
+		t.Fatal("Expected Pause to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, got %+v", samples)
+	}
+	for i, expected := range []string{"start", "pause"} {
+		s := samples[i]
+		if s.cmd != expected {
+			t.Errorf("Expected sample %d for %q, got %q", i, expected, s.cmd)
+		}
+		if s.d < 100*time.Millisecond || s.d > 10*time.Second {
+			t.Errorf("Implausible duration %s for %q", s.d, s.cmd)
+		}
+	}
+	var exitErr *ExitError
+	if samples[0].err != nil || !errors.As(samples[1].err, &exitErr) || exitErr.Status != 3 {
+		t.Fatalf("Unexpected errors %v and %v", samples[0].err, samples[1].err)
+	}
+}
+
+func TestRuncCreateNoPivotWarning(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "exit 0\n"),
+	}
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs", Readonly: true},
+	}
+	bundle := newTestBundle(t, spec)
+
+	warnings := make(chan string, 1)
+	if err := r.Create(context.Background(), "fake-id", bundle, &CreateOpts{NoPivot: true, Warnings: warnings}); err != nil {
+		t.Fatalf("Unexpected error from Create: %s", err)
+	}
+	select {
+	case w := <-warnings:
+		if !strings.Contains(w, "NoPivot") {
+			t.Fatalf("Unexpected warning %q", w)
+		}
+	default:
+		t.Fatal("Expected a warning for NoPivot with a read-only rootfs")
+	}
+
+	spec.Root.Readonly = false
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, spec), &CreateOpts{NoPivot: true, Warnings: warnings}); err != nil {
+		t.Fatalf("Unexpected error from Create: %s", err)
+	}
+	select {
+	case w := <-warnings:
+		t.Fatalf("Unexpected warning %q for a writable rootfs", w)
+	default:
+	}
+}
+
+func TestRuncDefaultOpts(t *testing.T) {
+	command, argv := newArgvRunc(t)
+	r := &Runc{
+		Command: command,
+		DefaultCreateOpts: &CreateOpts{
+			NoNewKeyring:  true,
+			ConsoleSocket: testConsoleSocket("/run/default.sock"),
+			ExtraArgs:     []string{"--default"},
+		},
+		DefaultExecOpts: &ExecOpts{
+			Detach: true,
+			Env:    []string{"FOO=default", "BAR=default"},
+		},
+	}
+
+	bundle := newTestBundle(t, nil)
+	if err := r.Create(context.Background(), "fake-id", bundle, &CreateOpts{
+		ConsoleSocket: testConsoleSocket("/run/call.sock"),
+	}); err != nil {
+		t.Fatalf("Unexpected error from Create: %s", err)
+	}
+	expected := []string{"create", "--bundle", bundle, "--console-socket", "/run/call.sock", "--no-new-keyring", "--default", "fake-id"}
+	if actual := argv(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+	if err := r.Create(context.Background(), "fake-id", bundle, nil); err != nil {
+		t.Fatalf("Unexpected error from Create: %s", err)
+	}
+	expected = []string{"create", "--bundle", bundle, "--console-socket", "/run/default.sock", "--no-new-keyring", "--default", "fake-id"}
+	if actual := argv(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected argv %q but got %q", expected, actual)
+	}
+
+	opts := &ExecOpts{Env: []string{"FOO=call"}}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{}, opts); err != nil {
+		t.Fatalf("Unexpected error from Exec: %s", err)
+	}
+	actual := argv()
+	if actual[len(actual)-2] != "--detach" {
+		t.Fatalf("expected the default --detach in argv %q", actual)
+	}
+	// the defaults don't leak into the options of the caller
+	if !reflect.DeepEqual(opts, &ExecOpts{Env: []string{"FOO=call"}}) {
+		t.Fatalf("expected the options to be unchanged, got %+v", opts)
+	}
+	if !reflect.DeepEqual(opts.withDefaults(r.DefaultExecOpts).Env, []string{"FOO=call", "BAR=default"}) {
+		t.Fatalf("unexpected merged env %q", opts.withDefaults(r.DefaultExecOpts).Env)
+	}
+}
+
+func TestRuncCommandError(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo '{"paused":true}'
+echo 'cannot resume: container is not paused' >&2
+exit 1
+`),
+	}
+	err := r.Resume(context.Background(), "fake-id")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected a CommandError, got %v", err)
+	}
+	if string(cmdErr.Stdout) != "{\"paused\":true}\n" {
+		t.Errorf("Unexpected stdout %q", cmdErr.Stdout)
+	}
+	if string(cmdErr.Stderr) != "cannot resume: container is not paused\n" {
+		t.Errorf("Unexpected stderr %q", cmdErr.Stderr)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 1 {
+		t.Errorf("Expected the exit status to be wrapped, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "container is not paused") {
+		t.Errorf("Expected stderr in the error message, got %s", err)
+	}
+}
+
+func TestRuncCommandErrorSubcommand(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `[ "$3" = "start" ] && exit 1; exit 0`),
+		Root:    "/run/runc",
+	}
+	err := r.CreateAndStart(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected a CommandError, got %v", err)
+	}
+	if cmdErr.Command != "start" || !reflect.DeepEqual(cmdErr.Args, []string{"fake-id"}) {
+		t.Fatalf("Expected the start subcommand and its args, got %q %q", cmdErr.Command, cmdErr.Args)
+	}
+	if !strings.Contains(err.Error(), "runc start: ") {
+		t.Fatalf("Expected the subcommand in the error message, got %s", err)
+	}
+
+	// the commands whose output is parsed name their subcommand as well
+	r.Command = newDummyRunc(t, "exit 1")
+	if _, err := r.State(context.Background(), "fake-id"); !errors.As(err, &cmdErr) || cmdErr.Command != "state" {
+		t.Fatalf("Expected a CommandError for state, got %v", err)
+	}
+	err = r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{
+		ExtraArgs: []string{"--env", "TOKEN=secret", "--env=KEY=secret"},
+	})
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "exec" {
+		t.Fatalf("Expected a CommandError for exec, got %v", err)
+	}
+	if args := strings.Join(cmdErr.Args, " "); strings.Contains(args, "secret") || !strings.Contains(args, "--env TOKEN=<redacted> --env=KEY=<redacted>") {
+		t.Fatalf("Expected the environment to be redacted, got %q", cmdErr.Args)
+	}
+}
+
+func TestRuncMaxErrorOutput(t *testing.T) {
+	// runc --debug logging a lot before the error
+	r := &Runc{
+		Command: newDummyRunc(t, `
+i=0
+while [ $i -lt 1000 ]; do
+	echo 'level=debug msg="nsexec-1[4242]: spawn stage-2"' >&2
+	i=$((i+1))
+done
+echo 'level=error msg="container is not paused"' >&2
+exit 1
+`),
+		Debug:          true,
+		MaxErrorOutput: 64,
+	}
+	err := r.Resume(context.Background(), "fake-id")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected a CommandError, got %v", err)
+	}
+	if !bytes.HasPrefix(cmdErr.Stderr, []byte("[")) || !bytes.Contains(cmdErr.Stderr, []byte("bytes truncated]...")) {
+		t.Fatalf("Expected the truncation to be reported, got %q", cmdErr.Stderr)
+	}
+	if !bytes.HasSuffix(cmdErr.Stderr, []byte("level=error msg=\"container is not paused\"\n")) {
+		t.Fatalf("Expected the tail of the output to be kept, got %q", cmdErr.Stderr)
+	}
+	_, tail, _ := strings.Cut(string(cmdErr.Stderr), "]...")
+	if len(tail) != 64 {
+		t.Fatalf("Expected the output to be capped to 64 bytes, got %d", len(tail))
+	}
+}
+
+func TestRuncCreateBundleCheck(t *testing.T) {
+	argv := filepath.Join(t.TempDir(), "argv")
+	r := &Runc{
+		Command: newDummyRunc(t, "echo \"$@\" > "+argv+"\n"),
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := r.Create(context.Background(), "fake-id", missing, nil); !errors.Is(err, ErrBundleNotFound) {
+		t.Fatalf("expected ErrBundleNotFound, got %v", err)
+	}
+	if _, err := r.Run(context.Background(), "fake-id", missing, nil); !errors.Is(err, ErrBundleNotFound) {
+		t.Fatalf("expected ErrBundleNotFound from Run, got %v", err)
+	}
+	empty := t.TempDir()
+	if err := r.Create(context.Background(), "fake-id", empty, nil); !errors.Is(err, ErrConfigMissing) {
+		t.Fatalf("expected ErrConfigMissing, got %v", err)
+	}
+	if _, err := os.Stat(argv); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected runc not to be run, got %v", err)
+	}
+}
+
+func TestRuncCreateCurrentBundle(t *testing.T) {
+	argv := filepath.Join(t.TempDir(), "argv")
+	r := &Runc{
+		Command: newDummyRunc(t, "echo \"$@\" > "+argv+"\n"),
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := r.Create(context.Background(), "fake-id", "", nil); !errors.Is(err, ErrConfigMissing) {
+		t.Fatalf("expected ErrConfigMissing for the current directory, got %v", err)
+	}
+	if err := os.Chdir(newTestBundle(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(context.Background(), "fake-id", "", nil); err != nil {
+		t.Fatalf("expected the current directory to be used as the bundle, got %v", err)
+	}
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "--bundle  ") {
+		t.Fatalf("expected an empty --bundle, got %q", data)
+	}
+}
+
+func TestRuncCreateHeartbeat(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, "sleep 0.5\n"),
+	}
+	var (
+		mu      sync.Mutex
+		elapsed []time.Duration
+	)
+	err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{
+		Heartbeat: func(d time.Duration) {
+			mu.Lock()
+			elapsed = append(elapsed, d)
+			mu.Unlock()
+		},
+		HeartbeatInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	beats := len(elapsed)
+	mu.Unlock()
+	if beats < 3 {
+		t.Fatalf("expected the heartbeat to fire during the create, got %d beats", beats)
+	}
+	for i := 1; i < beats; i++ {
+		if elapsed[i] <= elapsed[i-1] {
+			t.Fatalf("expected the elapsed time to grow, got %v", elapsed)
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(elapsed) != beats {
+		t.Fatal("expected the heartbeat to stop once create has returned")
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"fake-id", "abc_123", "pod.1+ctr", "A-Z", "a,b"} {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("expected %q to be valid, got %v", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "a/b", "../etc", "a b", "id;rm", "ctr\n", "café"} {
+		if err := ValidateID(id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("expected %q to be invalid, got %v", id, err)
+		}
+	}
+
+	r := &Runc{
+		Command: newDummyRunc(t, "exit 0\n"),
+	}
+	if err := r.Create(context.Background(), "../fake-id", newTestBundle(t, nil), nil); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("expected Create to reject the id, got %v", err)
+	}
+}
+
+func TestRuncDeleteRemoveBundle(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fail")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+if [ -e `+marker+` ]; then
+	echo "container is running" >&2
+	exit 1
+fi
+`),
+	}
+	bundle := newTestBundle(t, nil)
+
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(context.Background(), "fake-id", &DeleteOpts{RemoveBundle: bundle}); err == nil {
+		t.Fatal("Expected Delete to fail")
+	}
+	if _, err := os.Stat(filepath.Join(bundle, "config.json")); err != nil {
+		t.Fatalf("Expected the bundle to be kept after a failed delete: %s", err)
+	}
+
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(context.Background(), "fake-id", &DeleteOpts{RemoveBundle: bundle}); err != nil {
+		t.Fatalf("Unexpected error from Delete: %s", err)
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Fatalf("Expected the bundle to be removed, got %v", err)
+	}
+}
+
+func TestRuncStartMonitored(t *testing.T) {
+	exited := filepath.Join(t.TempDir(), "exited")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	if [ -e `+exited+` ]; then
+		echo '{"id":"fake-id","pid":0,"status":"stopped"}'
+	else
+		echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	fi
+	;;
+events)
+	# the container exits after a while
+	sleep 0.2
+	touch `+exited+`
+	;;
+esac
+`),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ec, err := r.StartMonitored(ctx, "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartMonitored: %s", err)
+	}
+	select {
+	case e := <-ec:
+		t.Fatalf("Unexpected exit %+v before the container exited", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case e, ok := <-ec:
+		if !ok || e.Pid != 4242 || e.Status != 255 {
+			t.Fatalf("unexpected exit %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the exit")
+	}
+}
+
+func TestRuncStartMonitoredNoEvents(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	// the runtime has no events, and the container stops from the fourth
+	// state call on
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	echo >> `+calls+`
+	if [ "$(wc -l < `+calls+`)" -ge 4 ]; then
+		echo '{"id":"fake-id","pid":0,"status":"stopped"}'
+	else
+		echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	fi
+	;;
+events)
+	echo "events is not supported" >&2
+	exit 1
+	;;
+esac
+`),
+		Timeout:            50 * time.Millisecond,
+		StatusPollInterval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ec, err := r.StartMonitored(ctx, "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartMonitored: %s", err)
+	}
+	select {
+	case e, ok := <-ec:
+		if !ok || e.Pid != 4242 {
+			t.Fatalf("unexpected exit %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the exit")
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n < 4 {
+		t.Fatalf("expected the exit to be sent once the container stopped, after %d state calls", n)
+	}
+}
+
+func TestRuncStartMonitoredShutdown(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	;;
+events)
+	exec sleep 10
+	;;
+esac
+`),
+		Timeout: 50 * time.Millisecond,
+	}
+	ec, err := r.StartMonitored(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from StartMonitored: %s", err)
+	}
+	// the events are not bounded by the timeout
+	select {
+	case e, ok := <-ec:
+		t.Fatalf("unexpected exit %+v, %v, while the container runs", e, ok)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := r.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e, ok := <-ec:
+		if ok {
+			t.Fatalf("expected no exit once shut down, got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the channel to be closed")
+	}
+}
+
+func TestRuncExecKillTimeout(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "term")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+trap 'echo term > `+marker+`' TERM
+while :; do sleep 0.05; done
+`),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := r.Exec(ctx, "fake-id", specs.Process{}, &ExecOpts{KillTimeout: 300 * time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected the cancelled exec to fail")
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 5*time.Second {
+		t.Fatalf("Expected the exec to be killed after the timeout, took %s", d)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected the exec to receive SIGTERM first: %s", err)
+	}
+}
+
+func TestRuncCreateIgnoreExisting(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo 'time="2023-01-02T03:04:05Z" level=error msg="container with id exists: fake-id"' >&2
+exit 1
+`),
+	}
+	err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	if !errors.Is(err, ErrContainerExists) {
+		t.Fatalf("expected ErrContainerExists, got %v", err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 1 {
+		t.Fatalf("expected the exit status to be wrapped, got %v", err)
+	}
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{IgnoreExisting: true}); err != nil {
+		t.Fatalf("expected no error with IgnoreExisting, got %v", err)
+	}
+
+	r.Command = newDummyRunc(t, "echo 'no such file or directory' >&2; exit 1\n")
+	if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{IgnoreExisting: true}); err == nil || errors.Is(err, ErrContainerExists) {
+		t.Fatalf("expected other errors to be returned with IgnoreExisting, got %v", err)
+	}
+}
+
+func TestRuncCreateExIgnoreExisting(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	bundle := newTestBundle(t, nil)
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo "$1" >> `+calls+`
+case "$1" in
+create)
+	echo 'time="2023-01-02T03:04:05Z" level=error msg="container with id exists: fake-id"' >&2
+	exit 1
+	;;
+state)
+	echo '{"id": "fake-id", "pid": 4242, "status": "running", "bundle": "`+bundle+`"}'
+	;;
+esac
+`),
+	}
+	result, err := r.CreateEx(context.Background(), "fake-id", bundle, &CreateOpts{IgnoreExisting: true})
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateEx: %s", err)
+	}
+	hash, err := BundleHash(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (CreateResult{Pid: 4242, ConfigHash: hash}); *result != expected {
+		t.Fatalf("expected %+v but got %+v", expected, *result)
+	}
+	if err := r.CreateAndStart(context.Background(), "fake-id", bundle, &CreateOpts{IgnoreExisting: true}); err != nil {
+		t.Fatalf("Unexpected error from CreateAndStart: %s", err)
+	}
+	// the existing container is neither started nor deleted
+	if data, err := os.ReadFile(calls); err != nil || string(data) != "create\nstate\ncreate\n" {
+		t.Fatalf("expected the existing container to be left as is, got calls %q, %v", data, err)
+	}
+}
+
+func TestRuncCreateHookFailed(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo 'time="2023-01-02T03:04:05Z" level=error msg="runc create failed: unable to start container process: error during container init: error running prestart hook #0: exit status 1, stdout: , stderr: no network"' >&2
+exit 1
+`),
+	}
+	err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	var hookErr *ErrHookFailed
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("expected an *ErrHookFailed, got %v", err)
+	}
+	if hookErr.Stage != "prestart" {
+		t.Fatalf("expected the prestart stage, got %q", hookErr.Stage)
+	}
+	if expected := "exit status 1, stdout: , stderr: no network"; hookErr.Output != expected {
+		t.Fatalf("expected output %q, got %q", expected, hookErr.Output)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 1 {
+		t.Fatalf("expected the hook failure to wrap the exit error, got %v", err)
+	}
+
+	if err := hookError(errors.New("exit status 1"), "container fake-id already exists"); errors.As(err, &hookErr) {
+		t.Fatalf("expected other failures not to be hook failures, got %v", err)
+	}
+}
+
+func TestRuncExecPaused(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log.json")
+	// like runc since v1.1
+	r := &Runc{
+		Command: newDummyRunc(t, `
+for arg; do
+	[ "$arg" = "--ignore-paused" ] && exit 0
+done
+echo "cannot exec in a paused container (use --ignore-paused to override)" >&2
+echo '{"level":"error","msg":"cannot exec in a paused container (use --ignore-paused to override)","time":"2023-01-02T03:04:05Z"}' >> `+log+`
+exit 1
+`),
+	}
+	err := r.Exec(context.Background(), "fake-id", specs.Process{}, nil)
+	if !errors.Is(err, ErrContainerPaused) {
+		t.Fatalf("expected ErrContainerPaused, got %v", err)
+	}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{IgnorePaused: true}); err != nil {
+		t.Fatalf("unexpected error with IgnorePaused: %v", err)
+	}
+
+	// with IO, the error is read from the log
+	r.Log, r.LogFormat = log, JSON
+	err = r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{IO: &bufferIO{}})
+	if !errors.Is(err, ErrContainerPaused) {
+		t.Fatalf("expected ErrContainerPaused with IO, got %v", err)
+	}
+}
+
+func TestRuncMaxConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	counts := filepath.Join(t.TempDir(), "counts")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+mkdir `+dir+`/$$
+ls `+dir+` | wc -l >> `+counts+`
+sleep 0.2
+rmdir `+dir+`/$$
+`),
+		MaxConcurrent: 2,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.Start(context.Background(), "fake-id"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := strings.Fields(string(data))
+	if len(runs) != 6 {
+		t.Fatalf("expected 6 runs, got %d", len(runs))
+	}
+	for _, n := range runs {
+		if n != "1" && n != "2" {
+			t.Fatalf("expected at most 2 commands to run at once, got %s", n)
+		}
+	}
+}
+
+func TestRuncMaxConcurrentLongCommand(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+events)
+	exec sleep 10
+	;;
+start)
+	exec sleep 1
+	;;
+esac
+`),
+		MaxConcurrent: 1,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the events stream doesn't hold a token while it is open
+	if _, err := r.Events(ctx, "fake-id", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	kctx, kcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer kcancel()
+	if err := r.Kill(kctx, "fake-id", int(syscall.SIGKILL), nil); err != nil {
+		t.Fatalf("expected kill to run with the events stream open, got %v", err)
+	}
+
+	// a command waiting for a token gives up once its context is done
+	go r.Start(context.Background(), "fake-id")
+	time.Sleep(100 * time.Millisecond)
+	kctx, kcancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer kcancel()
+	if err := r.Kill(kctx, "fake-id", int(syscall.SIGKILL), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for a token to time out, got %v", err)
+	}
+}
+
+// failingCloseIO is an IO failing to close after start
+type failingCloseIO struct{ IO }
+
+func (failingCloseIO) CloseAfterStart() error {
+	return errors.New("close failed")
+}
+
+func TestRuncCloseAfterStartError(t *testing.T) {
+	io, err := NewNullIO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer io.Close()
+	r := &Runc{
+		Command:       newDummyRunc(t, "exit 0\n"),
+		MaxConcurrent: 1,
+	}
+	err = r.Create(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{IO: failingCloseIO{io}})
+	if err == nil || err.Error() != "close failed" {
+		t.Fatalf("expected the close error, got %v", err)
+	}
+	// the command has been waited on, releasing its token
+	if st := r.state(); len(st.procs) != 0 || len(st.sem) != 0 {
+		t.Fatalf("expected the command not to be tracked anymore, got %d commands and %d tokens", len(st.procs), len(st.sem))
+	}
+}
+
+func TestRuncKillAll(t *testing.T) {
+	var (
+		cmds []*exec.Cmd
+		pids []int
+	)
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command("sleep", "10")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cmd.Process.Kill()
+		cmds = append(cmds, cmd)
+		pids = append(pids, cmd.Process.Pid)
+	}
+	// a pid beyond the maximum pid of linux, which can't be signaled
+	const gone = 1 << 30
+	listed := filepath.Join(t.TempDir(), "listed")
+	r := &Runc{
+		Command: newDummyRunc(t, fmt.Sprintf(`
+if [ -e %[1]s ]; then
+	echo '[%[2]d, %[4]d]'
+else
+	touch %[1]s
+	echo '[%[2]d, %[3]d, %[4]d, %[5]d]'
+fi
+`, listed, pids[0], gone, pids[1], pids[2])),
+	}
+	signaled, err := r.KillAll(context.Background(), "fake-id", int(syscall.SIGKILL))
+	if expected := pids[:2]; !reflect.DeepEqual(signaled, expected) {
+		t.Fatalf("expected pids %v to be signaled, got %v", expected, signaled)
+	}
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", gone)) {
+		t.Fatalf("expected an error for pid %d, got %v", gone, err)
+	}
+	// a process which has left the container since being listed is spared
+	if !errors.Is(err, os.ErrProcessDone) || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", pids[2])) {
+		t.Fatalf("expected pid %d not to be signaled, got %v", pids[2], err)
+	}
+	if err := cmds[2].Process.Signal(syscall.Signal(0)); err != nil {
+		t.Fatalf("expected pid %d to be running, got %v", pids[2], err)
+	}
+	for _, cmd := range cmds[:2] {
+		cmd.Wait()
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGKILL {
+			t.Fatalf("expected pid %d to be killed, got %v", cmd.Process.Pid, cmd.ProcessState)
+		}
+	}
+}
+
+func TestRuncKillMany(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$3" in
+bad-*)
+	echo "container $3 does not exist" >&2
+	exit 1
+	;;
+esac
+`),
+	}
+	ids := []string{"ctr-1", "bad-1", "ctr-2", "ctr-3", "bad-2"}
+	results := r.KillMany(context.Background(), ids, int(syscall.SIGKILL), &KillOpts{All: true})
+	if len(results) != len(ids) {
+		t.Fatalf("expected a result for each id, got %v", results)
+	}
+	for _, id := range ids {
+		err, ok := results[id]
+		if !ok {
+			t.Fatalf("expected a result for %s", id)
+		}
+		if !strings.HasPrefix(id, "bad-") {
+			if err != nil {
+				t.Fatalf("expected %s to be killed, got %v", id, err)
+			}
+			continue
+		}
+		var cmdErr *CommandError
+		if !errors.As(err, &cmdErr) || !strings.Contains(string(cmdErr.Stderr), id) {
+			t.Fatalf("expected the error of %s with its output, got %v", id, err)
+		}
+	}
+}
+
+func TestRuncStopSequence(t *testing.T) {
+	signals := filepath.Join(t.TempDir(), "signals")
+	// the container ignores every signal but SIGQUIT
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+kill)
+	echo "$3" >> `+signals+`
+	;;
+state)
+	if grep -qx 3 `+signals+` 2>/dev/null; then
+		echo '{"id":"fake-id","pid":0,"status":"stopped"}'
+	else
+		echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	fi
+	;;
+esac
+`),
+	}
+	sequence := []SignalStep{
+		{Signal: int(syscall.SIGTERM), Timeout: 50 * time.Millisecond},
+		{Signal: int(syscall.SIGINT), Timeout: 50 * time.Millisecond},
+		{Signal: int(syscall.SIGQUIT), Timeout: 10 * time.Second},
+		{Signal: int(syscall.SIGKILL), Timeout: 10 * time.Second},
+	}
+	if err := r.Stop(context.Background(), "fake-id", &StopOpts{StopSequence: sequence}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(signals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "15\n2\n3\n" {
+		t.Fatalf("expected SIGTERM, SIGINT then SIGQUIT to be sent, got %q", data)
+	}
+
+	// the container never stops
+	os.Remove(signals)
+	err = r.Stop(context.Background(), "fake-id", &StopOpts{StopSequence: sequence[:2]})
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("expected ErrStopTimeout, got %v", err)
+	}
+}
+
+func TestRuncWaitForStatus(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	// the container is running from the third state call on
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo >> `+calls+`
+if [ "$(wc -l < `+calls+`)" -ge 3 ]; then
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+else
+	echo '{"id":"fake-id","pid":4242,"status":"created"}'
+fi
+`),
+		StatusPollInterval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.WaitForStatus(ctx, "fake-id", StatusRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := r.WaitForStatus(ctx, "fake-id", StatusPaused); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		expected string
+	}{
+		{250 * time.Millisecond, "250ms"},
+		{time.Second, "1s"},
+		{90 * time.Second, "90s"},
+		{1500 * time.Millisecond, "1500ms"},
+		{1500 * time.Microsecond, "1.5ms"},
+	} {
+		actual, err := formatInterval(tc.interval)
+		if err != nil {
+			t.Fatalf("formatInterval(%s): unexpected error: %v", tc.interval, err)
+		}
+		if actual != tc.expected {
+			t.Errorf("formatInterval(%s): expected %q, actual %q", tc.interval, tc.expected, actual)
+		}
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, err := formatInterval(interval); err == nil {
+			t.Errorf("formatInterval(%s): expected an error", interval)
+		}
+	}
+}
+
+func TestRuncPrune(t *testing.T) {
+	deleted := filepath.Join(t.TempDir(), "deleted")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+list)
+	echo '[{"id":"a","status":"stopped"},{"id":"b","status":"running"},{"id":"c","status":"stopped"},{"id":"d","status":"paused"},{"id":"e","status":"stopped"}]'
+	;;
+delete)
+	id=$(eval echo \${$#})
+	if [ "$id" = "c" ]; then
+		echo "container c is busy" >&2
+		exit 1
+	fi
+	echo "$@" >> `+deleted+`
+	;;
+esac
+`),
+	}
+	pruned, err := r.Prune(context.Background())
+	if expected := []string{"a", "e"}; !reflect.DeepEqual(pruned, expected) {
+		t.Fatalf("expected %v to be pruned, got %v", expected, pruned)
+	}
+	if err == nil || !strings.Contains(err.Error(), "c: ") {
+		t.Fatalf("expected an error for c, got %v", err)
+	}
+	data, err := os.ReadFile(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "delete --force a\ndelete --force e\n"; string(data) != expected {
+		t.Fatalf("expected the stopped containers to be force deleted, got %q", data)
+	}
+}
+
+func TestRuncCreateAndStart(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	newRunc := func(failing string) *Runc {
+		return &Runc{
+			Command: newDummyRunc(t, `
+echo "$1" >> `+calls+`
+[ "$1" = "`+failing+`" ] && exit 1
+exit 0
+`),
+		}
+	}
+	for _, tc := range []struct {
+		failing string
+		calls   string
+		fails   bool
+	}{
+		{"", "create\nstart\n", false},
+		{"create", "create\n", true},
+		{"start", "create\nstart\ndelete\n", true},
+	} {
+		os.Remove(calls)
+		err := newRunc(tc.failing).CreateAndStart(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+		if tc.fails != (err != nil) {
+			t.Fatalf("%s failing: unexpected error %v", tc.failing, err)
+		}
+		data, err := os.ReadFile(calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.calls {
+			t.Errorf("%s failing: expected calls %q, got %q", tc.failing, tc.calls, data)
+		}
+	}
+
+	var startErr *ExitError
+	r := &Runc{
+		Command: newDummyRunc(t, `[ "$1" = "create" ] || exit 2`),
+	}
+	err := r.CreateAndStart(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	if !errors.As(err, &startErr) || !strings.Contains(err.Error(), "deleting fake-id after failing to start it") {
+		t.Fatalf("expected the start and delete errors, got %v", err)
+	}
+
+	// the container is deleted even once the context of the call is done
+	os.Remove(calls)
+	r = &Runc{
+		Command: newDummyRunc(t, `
+echo "$1" >> `+calls+`
+[ "$1" = "start" ] && exec sleep 5
+exit 0
+`),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := r.CreateAndStart(ctx, "fake-id", newTestBundle(t, nil), nil); err == nil {
+		t.Fatal("expected an error once the context is done")
+	}
+	if data, err := os.ReadFile(calls); err != nil || string(data) != "create\nstart\ndelete\n" {
+		t.Fatalf("expected the container to be deleted, got calls %q, %v", data, err)
+	}
+}
+
+func TestRuncEventsFilterID(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo '{"type":"stats","id":"fake-id","data":{}}'
+echo '{"type":"oom","id":"other-id"}'
+echo '{"type":"oom","id":"fake-id"}'
+echo '{"type":"stats","id":"","data":{}}'
+`),
+	}
+	c, err := r.EventsWithOpts(context.Background(), "fake-id", &EventsOpts{Interval: time.Second, FilterID: "fake-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for e := range c {
+		if e.ID != "fake-id" {
+			t.Fatalf("expected only the events of fake-id, got %+v", e)
+		}
+		types = append(types, e.Type)
+	}
+	if expected := []string{EventTypeStats, EventTypeOOM}; !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected events %v, got %v", expected, types)
+	}
+}
+
+func TestRuncJSONCodec(t *testing.T) {
+	process := filepath.Join(t.TempDir(), "process.json")
+	var marshaled, unmarshaled int
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	;;
+exec)
+	cp "$3" `+process+`
+	;;
+esac
+`),
+		JSONMarshal: func(v interface{}) ([]byte, error) {
+			marshaled++
+			return json.MarshalIndent(v, "", "\t")
+		},
+		JSONUnmarshal: func(data []byte, v interface{}) error {
+			unmarshaled++
+			return json.Unmarshal(data, v)
+		},
+	}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{Args: []string{"sh"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.State(context.Background(), "fake-id"); err != nil {
+		t.Fatal(err)
+	}
+	if marshaled != 1 {
+		t.Fatalf("expected the process to be encoded with the custom marshaler, got %d calls", marshaled)
+	}
+	if unmarshaled != 1 {
+		t.Fatalf("expected the state to be decoded with the custom unmarshaler, got %d calls", unmarshaled)
+	}
+	data, err := os.ReadFile(process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n\t\"args\": [") {
+		t.Fatalf("expected the process file written by the custom marshaler, got %s", data)
+	}
+}
+
+func TestRuncJSONUnmarshalOutput(t *testing.T) {
+	var unmarshaled int
+	r := &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+events)
+	echo '{"type":"stats","id":"fake-id","data":{"pids":{"current":3}}}'
+	;;
+ps)
+	echo '[1,2]'
+	;;
+esac
+`),
+		JSONUnmarshal: func(data []byte, v interface{}) error {
+			unmarshaled++
+			return json.Unmarshal(data, v)
+		},
+	}
+	stats, err := r.Stats(context.Background(), "fake-id")
+	if err != nil || stats.Pids.Current != 3 {
+		t.Fatalf("unexpected stats %+v, %v", stats, err)
+	}
+	if _, err := r.Ps(context.Background(), "fake-id"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Events(context.Background(), "fake-id", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range c {
+	}
+	// each output is decoded once, by the custom unmarshaler
+	if unmarshaled != 3 {
+		t.Fatalf("expected the stats, processes and event to be decoded with the custom unmarshaler, got %d calls", unmarshaled)
+	}
+}
+
+func TestRuncEventsStreamErr(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		types  []string
+		check  func(error) bool
+	}{
+		{"clean", `echo '{"type":"stats","id":"fake-id","data":{}}'`, []string{EventTypeStats}, func(err error) bool {
+			return err == nil
+		}},
+		{"decode error", `echo '{"type":"stats","id":"fake-id","data":{}}'; echo '{"type": oom'; echo '{"type":"oom","id":"fake-id"}'`, []string{EventTypeStats, EventTypeError, EventTypeOOM}, func(err error) bool {
+			var serr *json.SyntaxError
+			return errors.As(err, &serr)
+		}},
+		{"runc error", `echo '{"type":"oom","id":"fake-id"}'; exit 3`, []string{EventTypeOOM}, func(err error) bool {
+			var exitErr *ExitError
+			return errors.As(err, &exitErr) && exitErr.Status == 3
+		}},
+	} {
+		r := &Runc{
+			Command: newDummyRunc(t, tc.script+"\n"),
+		}
+		s, err := r.EventsStream(context.Background(), "fake-id", &EventsOpts{Interval: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for e := range s.Events() {
+			types = append(types, e.Type)
+			var serr *json.SyntaxError
+			if e.IsError() && !errors.As(e.Err, &serr) {
+				t.Errorf("%s: expected a syntax error event, got %v", tc.name, e.Err)
+			}
+		}
+		if !reflect.DeepEqual(types, tc.types) {
+			t.Errorf("%s: expected events %v, got %v", tc.name, tc.types, types)
+		}
+		if err := s.Err(); !tc.check(err) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+}
+
+func TestRuncEventsRaw(t *testing.T) {
+	input := `{"type":"intelrdt","id":"test","data":{"l3_cache_schema":"L3:0=ff"},"seq":7}`
+
+	r := &Runc{
+		Command: newDummyRunc(t, "echo '"+input+"'\n"),
+	}
+	c, err := r.Events(context.Background(), "test", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := <-c
+	for range c {
+	}
+	if e == nil {
+		t.Fatal("expected an event")
+	}
+	if e.Type != "intelrdt" || e.ID != "test" {
+		t.Fatalf("expected the typed fields to be decoded, got %+v", e)
+	}
+	var raw struct {
+		Seq  int `json:"seq"`
+		Data struct {
+			Schema string `json:"l3_cache_schema"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(e.Raw, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Seq != 7 || raw.Data.Schema != "L3:0=ff" {
+		t.Fatalf("expected the unknown fields in Raw, got %s", e.Raw)
+	}
+}
+
+func TestRuncRunOnExit(t *testing.T) {
+	for _, expected := range []int{0, 3} {
+		r := &Runc{
+			Command: newDummyRunc(t, fmt.Sprintf("exit %d\n", expected)),
+		}
+		var (
+			calls  int
+			status int
+		)
+		_, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{
+			OnExit: func(s int) {
+				calls++
+				status = s
+			},
+		})
+		if (err != nil) != (expected != 0) {
+			t.Fatalf("unexpected error from Run: %v", err)
+		}
+		if calls != 1 || status != expected {
+			t.Fatalf("expected OnExit to be called once with %d, got %d calls with %d", expected, calls, status)
+		}
+	}
+
+	// neither a detached container nor one which has not been run has exited
+	r := &Runc{
+		Command: newDummyRunc(t, "exit 0\n"),
+	}
+	opts := &CreateOpts{
+		OnExit: func(s int) {
+			t.Fatalf("unexpected call to OnExit with %d", s)
+		},
+	}
+	if _, err := r.Run(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{Detach: true, OnExit: opts.OnExit}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Run(context.Background(), "../fake-id", newTestBundle(t, nil), opts); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+}
+
+func TestRuncRunCaptured(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `
+echo "job output"
+echo "job warning" >&2
+exit 3
+`),
+	}
+	status, stdout, stderr, err := r.RunCaptured(context.Background(), "fake-id", newTestBundle(t, nil), nil)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 3 {
+		t.Fatalf("expected an exit error with status 3, got %v", err)
+	}
+	if status != 3 {
+		t.Fatalf("expected status 3, got %d", status)
+	}
+	if string(stdout) != "job output\n" || string(stderr) != "job warning\n" {
+		t.Fatalf("expected the job output, got stdout %q and stderr %q", stdout, stderr)
+	}
+
+	if _, _, _, err := r.RunCaptured(context.Background(), "fake-id", newTestBundle(t, nil), &CreateOpts{Detach: true}); err == nil {
+		t.Fatal("expected RunCaptured to refuse detaching")
+	}
+}
+
+func TestRuncWithIO(t *testing.T) {
+	r := &Runc{
+		Command: newDummyRunc(t, `echo "$1 output"`),
+	}
+	createOpts := &CreateOpts{NoPivot: true}
+	execOpts := &ExecOpts{}
+	for i := 0; i < 2; i++ {
+		cio, eio := &bufferIO{}, &bufferIO{}
+		if err := r.Create(context.Background(), "fake-id", newTestBundle(t, nil), createOpts.WithIO(cio)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Exec(context.Background(), "fake-id", specs.Process{Args: []string{"sh"}}, execOpts.WithIO(eio)); err != nil {
+			t.Fatal(err)
+		}
+		if cio.stdout.String() != "create output\n" || eio.stdout.String() != "exec output\n" {
+			t.Fatalf("expected the output in each IO, got %q and %q", cio.stdout.String(), eio.stdout.String())
+		}
+	}
+	if createOpts.IO != nil || execOpts.IO != nil {
+		t.Fatal("expected the options to be left unchanged")
+	}
+}
+
+func TestRuncExecProcessFileName(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	out := filepath.Join(t.TempDir(), "process")
+	r := &Runc{
+		Command: newDummyRunc(t, `echo "$3" > `+out+"\n"),
+	}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{Args: []string{"sh"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Base(strings.TrimSpace(string(data)))
+	if !strings.HasPrefix(name, "runc-process-fake-id-") || !strings.HasSuffix(name, ".json") {
+		t.Fatalf("expected the process file to be named after the container, got %s", name)
+	}
+}
+
+func TestRuncExecProcessFromStdin(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	out := t.TempDir()
+	r := &Runc{
+		Command: newDummyRunc(t, `
+[ "$1" = "exec" ] || exit 1
+[ "$3" = "/dev/stdin" ] || exit 2
+cat "$3" > `+filepath.Join(out, "process.json")+`
+ls -A "$XDG_RUNTIME_DIR" > `+filepath.Join(out, "runtime-dir")+`
+`),
+	}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{Args: []string{"sh"}}, &ExecOpts{ProcessFromStdin: true}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "process.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var process specs.Process
+	if err := json.Unmarshal(data, &process); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(process.Args, []string{"sh"}) {
+		t.Fatalf("expected the process spec on stdin, got %s", data)
+	}
+	if listing, err := os.ReadFile(filepath.Join(out, "runtime-dir")); err != nil || len(listing) != 0 {
+		t.Fatalf("expected no temporary file, got %q (%v)", listing, err)
+	}
+
+	stdio, err := NewSTDIO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Exec(context.Background(), "fake-id", specs.Process{}, &ExecOpts{IO: stdio, ProcessFromStdin: true}); err == nil {
+		t.Fatal("expected an error when IO sets stdin")
+	}
+}
+
+// helperPdeathsigParent starts the runc stub of GO_RUNC_TEST_COMMAND with a
+// SIGKILL parent death signal, and with Setpgid from GO_RUNC_TEST_SETPGID,
+// and blocks until it is killed
+func helperPdeathsigParent() {
+	r := &Runc{
+		Command:      os.Getenv("GO_RUNC_TEST_COMMAND"),
+		PdeathSignal: syscall.SIGKILL,
+		Setpgid:      os.Getenv("GO_RUNC_TEST_SETPGID") == "true",
+	}
+	r.Start(context.Background(), "fake-id")
+	os.Exit(2)
+}
@@ -0,0 +1,323 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package runctest provides a fake runc.Runtime to test code using go-runc
+// without a runc binary.
+package runctest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+// Call is a method call recorded by FakeRuntime
+type Call struct {
+	// Method is the name of the runc.Runtime method called
+	Method string
+	// Args are the arguments of the call, without the context
+	Args []interface{}
+}
+
+// FakeRuntime is a runc.Runtime recording its calls and returning the
+// responses of its Func fields. Methods whose Func is nil return zero values
+// and no error.
+//
+// FakeRuntime is safe for concurrent use, but its Func fields must not be
+// changed while it is in use.
+type FakeRuntime struct {
+	ListFunc       func(context context.Context) ([]*runc.Container, error)
+	StateFunc      func(context context.Context, id string) (*runc.Container, error)
+	CreateFunc     func(context context.Context, id, bundle string, opts *runc.CreateOpts) error
+	StartFunc      func(context context.Context, id string) error
+	ExecFunc       func(context context.Context, id string, spec specs.Process, opts *runc.ExecOpts) error
+	RunFunc        func(context context.Context, id, bundle string, opts *runc.CreateOpts) (int, error)
+	DeleteFunc     func(context context.Context, id string, opts *runc.DeleteOpts) error
+	KillFunc       func(context context.Context, id string, sig int, opts *runc.KillOpts) error
+	StatsFunc      func(context context.Context, id string) (*runc.Stats, error)
+	EventsFunc     func(context context.Context, id string, interval time.Duration) (chan *runc.Event, error)
+	PauseFunc      func(context context.Context, id string) error
+	ResumeFunc     func(context context.Context, id string) error
+	PsFunc         func(context context.Context, id string) ([]int, error)
+	TopFunc        func(context context.Context, id string, psOptions string) (*runc.TopResults, error)
+	CheckpointFunc func(context context.Context, id string, opts *runc.CheckpointOpts, actions ...runc.CheckpointAction) error
+	RestoreFunc    func(context context.Context, id, bundle string, opts *runc.RestoreOpts) (int, error)
+	UpdateFunc     func(context context.Context, id string, resources *specs.LinuxResources) error
+	VersionFunc    func(context context.Context) (runc.Version, error)
+	FeaturesFunc   func(context context.Context) (*features.Features, error)
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+var _ runc.Runtime = &FakeRuntime{}
+
+func (f *FakeRuntime) record(method string, args ...interface{}) {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	f.mu.Unlock()
+}
+
+// Calls returns the calls recorded so far, in order
+func (f *FakeRuntime) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the recorded calls to method, in order
+func (f *FakeRuntime) CallsTo(method string) []Call {
+	var out []Call
+	for _, c := range f.Calls() {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Reset forgets the recorded calls
+func (f *FakeRuntime) Reset() {
+	f.mu.Lock()
+	f.calls = nil
+	f.mu.Unlock()
+}
+
+// TB is the subset of testing.TB used by the assertion helpers
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertCalled reports an error to t unless method was called with args.
+// Without args, any call to method matches.
+func (f *FakeRuntime) AssertCalled(t TB, method string, args ...interface{}) bool {
+	t.Helper()
+	calls := f.CallsTo(method)
+	for _, c := range calls {
+		if len(args) == 0 || reflect.DeepEqual(c.Args, args) {
+			return true
+		}
+	}
+	if len(calls) == 0 {
+		t.Errorf("expected a call to %s, got none", method)
+	} else {
+		t.Errorf("expected a call to %s with %s, got %s", method, formatArgs(args), formatCalls(calls))
+	}
+	return false
+}
+
+// AssertNotCalled reports an error to t if method was called
+func (f *FakeRuntime) AssertNotCalled(t TB, method string) bool {
+	t.Helper()
+	if calls := f.CallsTo(method); len(calls) > 0 {
+		t.Errorf("expected no call to %s, got %s", method, formatCalls(calls))
+		return false
+	}
+	return true
+}
+
+func formatArgs(args []interface{}) string {
+	return fmt.Sprintf("%+v", args)
+}
+
+func formatCalls(calls []Call) string {
+	out := make([]string, 0, len(calls))
+	for _, c := range calls {
+		out = append(out, formatArgs(c.Args))
+	}
+	return fmt.Sprint(out)
+}
+
+// List records the call and returns the response of ListFunc
+func (f *FakeRuntime) List(context context.Context) ([]*runc.Container, error) {
+	f.record("List")
+	if f.ListFunc != nil {
+		return f.ListFunc(context)
+	}
+	return nil, nil
+}
+
+// State records the call and returns the response of StateFunc
+func (f *FakeRuntime) State(context context.Context, id string) (*runc.Container, error) {
+	f.record("State", id)
+	if f.StateFunc != nil {
+		return f.StateFunc(context, id)
+	}
+	return &runc.Container{ID: id}, nil
+}
+
+// Create records the call and returns the response of CreateFunc
+func (f *FakeRuntime) Create(context context.Context, id, bundle string, opts *runc.CreateOpts) error {
+	f.record("Create", id, bundle, opts)
+	if f.CreateFunc != nil {
+		return f.CreateFunc(context, id, bundle, opts)
+	}
+	return nil
+}
+
+// Start records the call and returns the response of StartFunc
+func (f *FakeRuntime) Start(context context.Context, id string) error {
+	f.record("Start", id)
+	if f.StartFunc != nil {
+		return f.StartFunc(context, id)
+	}
+	return nil
+}
+
+// Exec records the call and returns the response of ExecFunc
+func (f *FakeRuntime) Exec(context context.Context, id string, spec specs.Process, opts *runc.ExecOpts) error {
+	f.record("Exec", id, spec, opts)
+	if f.ExecFunc != nil {
+		return f.ExecFunc(context, id, spec, opts)
+	}
+	return nil
+}
+
+// Run records the call and returns the response of RunFunc
+func (f *FakeRuntime) Run(context context.Context, id, bundle string, opts *runc.CreateOpts) (int, error) {
+	f.record("Run", id, bundle, opts)
+	if f.RunFunc != nil {
+		return f.RunFunc(context, id, bundle, opts)
+	}
+	return 0, nil
+}
+
+// Delete records the call and returns the response of DeleteFunc
+func (f *FakeRuntime) Delete(context context.Context, id string, opts *runc.DeleteOpts) error {
+	f.record("Delete", id, opts)
+	if f.DeleteFunc != nil {
+		return f.DeleteFunc(context, id, opts)
+	}
+	return nil
+}
+
+// Kill records the call and returns the response of KillFunc
+func (f *FakeRuntime) Kill(context context.Context, id string, sig int, opts *runc.KillOpts) error {
+	f.record("Kill", id, sig, opts)
+	if f.KillFunc != nil {
+		return f.KillFunc(context, id, sig, opts)
+	}
+	return nil
+}
+
+// Stats records the call and returns the response of StatsFunc
+func (f *FakeRuntime) Stats(context context.Context, id string) (*runc.Stats, error) {
+	f.record("Stats", id)
+	if f.StatsFunc != nil {
+		return f.StatsFunc(context, id)
+	}
+	return &runc.Stats{}, nil
+}
+
+// Events records the call and returns the response of EventsFunc. Without
+// EventsFunc, the returned channel is closed.
+func (f *FakeRuntime) Events(context context.Context, id string, interval time.Duration) (chan *runc.Event, error) {
+	f.record("Events", id, interval)
+	if f.EventsFunc != nil {
+		return f.EventsFunc(context, id, interval)
+	}
+	c := make(chan *runc.Event)
+	close(c)
+	return c, nil
+}
+
+// Pause records the call and returns the response of PauseFunc
+func (f *FakeRuntime) Pause(context context.Context, id string) error {
+	f.record("Pause", id)
+	if f.PauseFunc != nil {
+		return f.PauseFunc(context, id)
+	}
+	return nil
+}
+
+// Resume records the call and returns the response of ResumeFunc
+func (f *FakeRuntime) Resume(context context.Context, id string) error {
+	f.record("Resume", id)
+	if f.ResumeFunc != nil {
+		return f.ResumeFunc(context, id)
+	}
+	return nil
+}
+
+// Ps records the call and returns the response of PsFunc
+func (f *FakeRuntime) Ps(context context.Context, id string) ([]int, error) {
+	f.record("Ps", id)
+	if f.PsFunc != nil {
+		return f.PsFunc(context, id)
+	}
+	return nil, nil
+}
+
+// Top records the call and returns the response of TopFunc
+func (f *FakeRuntime) Top(context context.Context, id string, psOptions string) (*runc.TopResults, error) {
+	f.record("Top", id, psOptions)
+	if f.TopFunc != nil {
+		return f.TopFunc(context, id, psOptions)
+	}
+	return &runc.TopResults{}, nil
+}
+
+// Checkpoint records the call and returns the response of CheckpointFunc
+func (f *FakeRuntime) Checkpoint(context context.Context, id string, opts *runc.CheckpointOpts, actions ...runc.CheckpointAction) error {
+	f.record("Checkpoint", id, opts)
+	if f.CheckpointFunc != nil {
+		return f.CheckpointFunc(context, id, opts, actions...)
+	}
+	return nil
+}
+
+// Restore records the call and returns the response of RestoreFunc
+func (f *FakeRuntime) Restore(context context.Context, id, bundle string, opts *runc.RestoreOpts) (int, error) {
+	f.record("Restore", id, bundle, opts)
+	if f.RestoreFunc != nil {
+		return f.RestoreFunc(context, id, bundle, opts)
+	}
+	return 0, nil
+}
+
+// Update records the call and returns the response of UpdateFunc
+func (f *FakeRuntime) Update(context context.Context, id string, resources *specs.LinuxResources) error {
+	f.record("Update", id, resources)
+	if f.UpdateFunc != nil {
+		return f.UpdateFunc(context, id, resources)
+	}
+	return nil
+}
+
+// Version records the call and returns the response of VersionFunc
+func (f *FakeRuntime) Version(context context.Context) (runc.Version, error) {
+	f.record("Version")
+	if f.VersionFunc != nil {
+		return f.VersionFunc(context)
+	}
+	return runc.Version{}, nil
+}
+
+// Features records the call and returns the response of FeaturesFunc
+func (f *FakeRuntime) Features(context context.Context) (*features.Features, error) {
+	f.record("Features")
+	if f.FeaturesFunc != nil {
+		return f.FeaturesFunc(context)
+	}
+	return &features.Features{}, nil
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	runc "github.com/containerd/go-runc"
+)
+
+// recordingTB records the errors reported by the assertion helpers
+type recordingTB struct {
+	errors []string
+}
+
+func (t *recordingTB) Helper() {}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestFakeRuntimeCreate(t *testing.T) {
+	errCreate := errors.New("create failed")
+	f := &FakeRuntime{
+		CreateFunc: func(_ context.Context, id, _ string, _ *runc.CreateOpts) error {
+			if id == "bad-id" {
+				return errCreate
+			}
+			return nil
+		},
+	}
+	var r runc.Runtime = f
+
+	opts := &runc.CreateOpts{PidFile: "/run/init.pid"}
+	if err := r.Create(context.Background(), "fake-id", "/run/bundle", opts); err != nil {
+		t.Fatalf("unexpected error from Create: %s", err)
+	}
+	if err := r.Create(context.Background(), "bad-id", "/run/bundle", nil); !errors.Is(err, errCreate) {
+		t.Fatalf("expected the canned error, got %v", err)
+	}
+	if _, err := r.State(context.Background(), "fake-id"); err != nil {
+		t.Fatalf("unexpected error from State: %s", err)
+	}
+
+	if calls := f.CallsTo("Create"); len(calls) != 2 {
+		t.Fatalf("expected 2 calls to Create, got %+v", calls)
+	}
+	if calls := f.Calls(); len(calls) != 3 || calls[2].Method != "State" {
+		t.Fatalf("unexpected calls %+v", calls)
+	}
+	f.AssertCalled(t, "Create", "fake-id", "/run/bundle", opts)
+	f.AssertCalled(t, "State")
+	f.AssertNotCalled(t, "Start")
+
+	var rt recordingTB
+	if f.AssertCalled(&rt, "Create", "other-id", "/run/bundle", opts) || f.AssertNotCalled(&rt, "Create") {
+		t.Fatal("expected the assertions to fail")
+	}
+	if len(rt.errors) != 2 {
+		t.Fatalf("expected 2 assertion errors, got %q", rt.errors)
+	}
+
+	f.Reset()
+	if calls := f.Calls(); len(calls) != 0 {
+		t.Fatalf("expected no calls after Reset, got %+v", calls)
+	}
+}
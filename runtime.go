@@ -0,0 +1,53 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+// Runtime is the container lifecycle implemented by Runc.
+//
+// Callers can depend on Runtime rather than *Runc to substitute the runtime
+// in their tests, see the runctest package for a fake implementation.
+type Runtime interface {
+	List(context context.Context) ([]*Container, error)
+	State(context context.Context, id string) (*Container, error)
+	Create(context context.Context, id, bundle string, opts *CreateOpts) error
+	Start(context context.Context, id string) error
+	Exec(context context.Context, id string, spec specs.Process, opts *ExecOpts) error
+	Run(context context.Context, id, bundle string, opts *CreateOpts) (int, error)
+	Delete(context context.Context, id string, opts *DeleteOpts) error
+	Kill(context context.Context, id string, sig int, opts *KillOpts) error
+	Stats(context context.Context, id string) (*Stats, error)
+	Events(context context.Context, id string, interval time.Duration) (chan *Event, error)
+	Pause(context context.Context, id string) error
+	Resume(context context.Context, id string) error
+	Ps(context context.Context, id string) ([]int, error)
+	Top(context context.Context, id string, psOptions string) (*TopResults, error)
+	Checkpoint(context context.Context, id string, opts *CheckpointOpts, actions ...CheckpointAction) error
+	Restore(context context.Context, id, bundle string, opts *RestoreOpts) (int, error)
+	Update(context context.Context, id string, resources *specs.LinuxResources) error
+	Version(context context.Context) (Version, error)
+	Features(context context.Context) (*features.Features, error)
+}
+
+var _ Runtime = &Runc{}
@@ -0,0 +1,105 @@
+//go:build !windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// SeccompNotify is the seccomp notify fd of a container process received
+// from runc
+type SeccompNotify struct {
+	// File is the seccomp notify fd used to handle the notifications
+	File *os.File
+	// State is the state of the container process sent along with the fd
+	State specs.ContainerProcessState
+}
+
+// NewSeccompNotifySocket creates a new unix socket at the provided path to
+// accept the seccomp notify fd sent by runc. The path has to be set as the
+// listenerPath of the seccomp profile of the container.
+func NewSeccompNotifySocket(path string) (*Socket, error) {
+	return NewConsoleSocket(path)
+}
+
+// ReceiveSeccompNotify blocks until the socket receives the seccomp notify
+// fd of a container process
+func (c *Socket) ReceiveSeccompNotify() (*SeccompNotify, error) {
+	conn, err := c.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("received connection which was not a unix socket")
+	}
+	return recvSeccompNotify(uc)
+}
+
+// recvSeccompNotify reads the container process state and the file
+// descriptors it names, sent as a single message by runc
+func recvSeccompNotify(socket *net.UnixConn) (*SeccompNotify, error) {
+	const (
+		maxStateLen = 1 << 16
+		maxFds      = 16
+	)
+	data := make([]byte, maxStateLen)
+	oob := make([]byte, unix.CmsgSpace(maxFds*4))
+
+	n, oobn, _, _, err := socket.ReadMsgUnix(data, oob)
+	if err != nil {
+		return nil, err
+	}
+	scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, scm := range scms {
+		rights, err := unix.ParseUnixRights(&scm)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+
+	var notify SeccompNotify
+	err = json.Unmarshal(data[:n], &notify.State)
+	for i, fd := range fds {
+		if err == nil && notify.File == nil && i < len(notify.State.Fds) && notify.State.Fds[i] == specs.SeccompFdName {
+			notify.File = os.NewFile(uintptr(fd), specs.SeccompFdName)
+			continue
+		}
+		unix.Close(fd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recvseccomp: failed to parse container process state: %w", err)
+	}
+	if notify.File == nil {
+		return nil, fmt.Errorf("recvseccomp: no %s received (fds=%v)", specs.SeccompFdName, notify.State.Fds)
+	}
+	return &notify, nil
+}
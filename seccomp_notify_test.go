@@ -0,0 +1,90 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestReceiveSeccompNotify(t *testing.T) {
+	s, err := NewSeccompNotifySocket(filepath.Join(t.TempDir(), "seccomp.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// stand in for the seccomp notify fd with a pipe
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	defer wr.Close()
+
+	state, err := json.Marshal(specs.ContainerProcessState{
+		Version: specs.Version,
+		Fds:     []string{specs.SeccompFdName},
+		Pid:     4242,
+		State:   specs.State{ID: "fake-id", Status: specs.StateCreating},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: s.Path(), Net: "unix"})
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = conn.WriteMsgUnix(state, unix.UnixRights(int(wr.Fd())), nil)
+		errc <- err
+	}()
+
+	notify, err := s.ReceiveSeccompNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notify.File.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if notify.State.Pid != 4242 || notify.State.State.ID != "fake-id" {
+		t.Fatalf("unexpected container process state %+v", notify.State)
+	}
+	if _, err := notify.File.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	wr.Close()
+	notify.File.Close()
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ping" {
+		t.Fatalf("expected the received fd to be the sent one, read %q", data)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// AppliedSettings holds the process settings in effect for the init process
+// of a container, allowing to verify that those of the spec took effect
+type AppliedSettings struct {
+	// Rlimits are the resource limits of the process, using math.MaxUint64
+	// for the unlimited ones
+	Rlimits []specs.POSIXRlimit
+	// OOMScoreAdj is the oom_score_adj of the process
+	OOMScoreAdj int
+}
+
+// RuntimeSettings returns the settings applied to the init process of the
+// running container, read from its procfs entry
+func (r *Runc) RuntimeSettings(context context.Context, id string) (*AppliedSettings, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+	return processSettings(c.Pid)
+}
+
+// The seccomp modes of a process, as reported by SeccompMode
+const (
+	SeccompModeDisabled = 0
+	SeccompModeStrict   = 1
+	SeccompModeFilter   = 2
+)
+
+// SeccompMode returns the seccomp mode of the init process of the running
+// container, as reported by the Seccomp field of its procfs status. A
+// container running with a seccomp profile is in SeccompModeFilter.
+func (r *Runc) SeccompMode(context context.Context, id string) (int, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return 0, err
+	}
+	if c.Pid == 0 {
+		return 0, fmt.Errorf("container %s is not running", id)
+	}
+	return processSeccompMode(c.Pid)
+}
+
+// NamespacePids returns the pids of the host process in each of its nested
+// pid namespaces, from the host's to the innermost one, as listed by the
+// NSpid field of its procfs status
+func NamespacePids(pid int) ([]int, error) {
+	return processNamespacePids(pid)
+}
+
+// ContainerPid maps the host pid of a process to its pid in the innermost
+// pid namespace it is in. For the init process of a container, whose host
+// pid is the Pid of its state, it is 1 when the container has its own pid
+// namespace.
+func ContainerPid(pid int) (int, error) {
+	pids, err := processNamespacePids(pid)
+	if err != nil {
+		return 0, err
+	}
+	if len(pids) == 0 {
+		return 0, fmt.Errorf("no namespace pids for process %d", pid)
+	}
+	return pids[len(pids)-1], nil
+}
@@ -0,0 +1,162 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// procLimits maps the names of the limits in /proc/<pid>/limits to the
+// rlimit types of the spec
+var procLimits = []struct {
+	name, typ string
+}{
+	{"Max cpu time", "RLIMIT_CPU"},
+	{"Max file size", "RLIMIT_FSIZE"},
+	{"Max data size", "RLIMIT_DATA"},
+	{"Max stack size", "RLIMIT_STACK"},
+	{"Max core file size", "RLIMIT_CORE"},
+	{"Max resident set", "RLIMIT_RSS"},
+	{"Max processes", "RLIMIT_NPROC"},
+	{"Max open files", "RLIMIT_NOFILE"},
+	{"Max locked memory", "RLIMIT_MEMLOCK"},
+	{"Max address space", "RLIMIT_AS"},
+	{"Max file locks", "RLIMIT_LOCKS"},
+	{"Max pending signals", "RLIMIT_SIGPENDING"},
+	{"Max msgqueue size", "RLIMIT_MSGQUEUE"},
+	{"Max nice priority", "RLIMIT_NICE"},
+	{"Max realtime priority", "RLIMIT_RTPRIO"},
+	{"Max realtime timeout", "RLIMIT_RTTIME"},
+}
+
+// processSettings reads the settings of the process from procfs
+func processSettings(pid int) (*AppliedSettings, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	rlimits, err := readProcLimits(filepath.Join(dir, "limits"))
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "oom_score_adj"))
+	if err != nil {
+		return nil, err
+	}
+	adj, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid oom_score_adj: %w", err)
+	}
+	return &AppliedSettings{
+		Rlimits:     rlimits,
+		OOMScoreAdj: adj,
+	}, nil
+}
+
+// readProcLimits parses /proc/<pid>/limits, whose limit names contain
+// spaces and are followed by the soft limit, the hard limit and the units
+func readProcLimits(path string) ([]specs.POSIXRlimit, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rlimits []specs.POSIXRlimit
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		for _, l := range procLimits {
+			rest, ok := strings.CutPrefix(line, l.name+" ")
+			if !ok {
+				continue
+			}
+			fields := strings.Fields(rest)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid limit %q", line)
+			}
+			soft, err := parseProcLimit(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			hard, err := parseProcLimit(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			rlimits = append(rlimits, specs.POSIXRlimit{Type: l.typ, Hard: hard, Soft: soft})
+			break
+		}
+	}
+	return rlimits, s.Err()
+}
+
+func parseProcLimit(s string) (uint64, error) {
+	if s == "unlimited" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// processSeccompMode reads the seccomp mode of the process from procfs
+func processSeccompMode(pid int) (int, error) {
+	v, err := readProcStatus(pid, "Seccomp")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// processNamespacePids reads the NSpid field of the process status
+func processNamespacePids(pid int) ([]int, error) {
+	v, err := readProcStatus(pid, "NSpid")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, f := range strings.Fields(v) {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NSpid of process %d: %w", pid, err)
+		}
+		pids = append(pids, n)
+	}
+	return pids, nil
+}
+
+// readProcStatus returns the value of the field of the procfs status of
+// the process
+func readProcStatus(pid int, field string) (string, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), field+":"); ok {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no %s in the status of process %d", field, pid)
+}
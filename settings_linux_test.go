@@ -0,0 +1,101 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestRuntimeSettings(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/limits": `Limit                     Soft Limit           Hard Limit           Units     
+Max cpu time              unlimited            unlimited            seconds   
+Max core file size        0                    unlimited            bytes     
+Max processes             1024                 2048                 processes 
+Max open files            1024                 4096                 files     
+Max nice priority         0                    0                    
+`,
+		"proc/4242/oom_score_adj": "-998\n",
+	})
+	s, err := newNoEventsRunc(t).RuntimeSettings(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &AppliedSettings{
+		Rlimits: []specs.POSIXRlimit{
+			{Type: "RLIMIT_CPU", Hard: math.MaxUint64, Soft: math.MaxUint64},
+			{Type: "RLIMIT_CORE", Hard: math.MaxUint64, Soft: 0},
+			{Type: "RLIMIT_NPROC", Hard: 2048, Soft: 1024},
+			{Type: "RLIMIT_NOFILE", Hard: 4096, Soft: 1024},
+			{Type: "RLIMIT_NICE", Hard: 0, Soft: 0},
+		},
+		OOMScoreAdj: -998,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, s)
+	}
+}
+
+func TestSeccompMode(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/status": "Name:\tsh\nUmask:\t0022\nState:\tS (sleeping)\nNoNewPrivs:\t1\nSeccomp:\t2\nSeccomp_filters:\t1\n",
+	})
+	mode, err := newNoEventsRunc(t).SeccompMode(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != SeccompModeFilter {
+		t.Fatalf("expected seccomp mode %d, got %d", SeccompModeFilter, mode)
+	}
+
+	newCgroupTree(t, map[string]string{
+		"proc/4242/status": "Name:\tsh\n",
+	})
+	if _, err := newNoEventsRunc(t).SeccompMode(context.Background(), "fake-id"); err == nil {
+		t.Fatal("expected an error without the seccomp mode")
+	}
+}
+
+func TestContainerPid(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		// a process in a container nested in another one
+		"proc/4242/status": "Name:\tsh\nTgid:\t4242\nNgid:\t0\nPid:\t4242\nPPid:\t4241\nNSpid:\t4242\t87\t1\n",
+		"proc/4243/status": "Name:\tsh\nPid:\t4243\n",
+	})
+	pids, err := NamespacePids(4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pids, []int{4242, 87, 1}) {
+		t.Fatalf("expected the pids of each namespace, got %v", pids)
+	}
+	pid, err := ContainerPid(4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != 1 {
+		t.Fatalf("expected the init to be pid 1 in the container, got %d", pid)
+	}
+	if _, err := ContainerPid(4243); err == nil {
+		t.Fatal("expected an error without NSpid")
+	}
+}
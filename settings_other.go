@@ -0,0 +1,33 @@
+//go:build !linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import "errors"
+
+func processSettings(pid int) (*AppliedSettings, error) {
+	return nil, errors.New("reading the process settings is only supported on linux")
+}
+
+func processSeccompMode(pid int) (int, error) {
+	return 0, errors.New("reading the seccomp mode is only supported on linux")
+}
+
+func processNamespacePids(pid int) ([]int, error) {
+	return nil, errors.New("reading the namespace pids is only supported on linux")
+}
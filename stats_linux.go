@@ -0,0 +1,550 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+var (
+	// procRoot is where procfs is mounted
+	procRoot = "/proc"
+	// cgroupRoot is where the cgroup filesystems are mounted
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// userHz is the unit of the times in cpuacct.stat, see USER_HZ
+const userHz = 100
+
+// cgroupStats reads the statistics of the container directly from the
+// cgroup filesystem, for runtimes which can't report them with `events`
+func (r *Runc) cgroupStats(context context.Context, id string) (*Stats, error) {
+	c, err := r.State(context, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+	paths, err := processCgroups(c.Pid)
+	if err != nil {
+		return nil, err
+	}
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		return cgroupV2Stats(filepath.Join(cgroupRoot, path))
+	}
+	return cgroupV1Stats(paths), nil
+}
+
+// processCgroups returns the cgroup of the process for each controller
+// hierarchy, keyed by the controllers of the hierarchy, or by name=<name>
+// for the named hierarchies. The cgroup v2 hierarchy has no controllers and
+// is keyed by "".
+func processCgroups(pid int) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		paths[parts[1]] = parts[2]
+	}
+	return paths, s.Err()
+}
+
+func cgroupV2Stats(dir string) (*Stats, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	var s Stats
+	cpu := readCgroupKV(filepath.Join(dir, "cpu.stat"))
+	s.Cpu.Usage.Total = cpu["usage_usec"] * 1000
+	s.Cpu.Usage.User = cpu["user_usec"] * 1000
+	s.Cpu.Usage.Kernel = cpu["system_usec"] * 1000
+	s.Cpu.Throttling.Periods = cpu["nr_periods"]
+	s.Cpu.Throttling.ThrottledPeriods = cpu["nr_throttled"]
+	s.Cpu.Throttling.ThrottledTime = cpu["throttled_usec"] * 1000
+
+	s.Memory.Raw = readCgroupKV(filepath.Join(dir, "memory.stat"))
+	s.Memory.Cache = s.Memory.Raw["file"]
+	s.Memory.Usage = MemoryEntry{
+		Usage:   readCgroupUint(filepath.Join(dir, "memory.current")),
+		Limit:   readCgroupUint(filepath.Join(dir, "memory.max")),
+		Max:     readCgroupUint(filepath.Join(dir, "memory.peak")),
+		Failcnt: readCgroupKV(filepath.Join(dir, "memory.events"))["max"],
+	}
+	// as runc does, swap is reported as memory+swap like with cgroup v1
+	s.Memory.Swap = MemoryEntry{
+		Usage: s.Memory.Usage.Usage + readCgroupUint(filepath.Join(dir, "memory.swap.current")),
+		Limit: addLimits(s.Memory.Usage.Limit, readCgroupUint(filepath.Join(dir, "memory.swap.max"))),
+	}
+
+	s.Pids.Current = readCgroupUint(filepath.Join(dir, "pids.current"))
+	s.Pids.Limit = pidsLimit(readCgroupUint(filepath.Join(dir, "pids.max")))
+
+	s.Blkio.IoServiceBytesRecursive, s.Blkio.IoServicedRecursive = readIoStat(filepath.Join(dir, "io.stat"))
+
+	for file, section := range map[string]StatsSection{
+		"cpu.stat":       StatsCpu,
+		"memory.current": StatsMemory,
+		"pids.current":   StatsPids,
+		"io.stat":        StatsBlkio,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			s.Present |= section
+		}
+	}
+	return &s, nil
+}
+
+func cgroupV1Stats(paths map[string]string) *Stats {
+	var s Stats
+	dir := func(controller, file string) string {
+		for controllers, path := range paths {
+			for _, c := range strings.Split(controllers, ",") {
+				if c == controller {
+					return filepath.Join(cgroupRoot, controllers, path, file)
+				}
+			}
+		}
+		return ""
+	}
+
+	s.Cpu.Usage.Total = readCgroupUint(dir("cpuacct", "cpuacct.usage"))
+	s.Cpu.Usage.Percpu = readCgroupUints(dir("cpuacct", "cpuacct.usage_percpu"))
+	cpuacct := readCgroupKV(dir("cpuacct", "cpuacct.stat"))
+	s.Cpu.Usage.User = cpuacct["user"] * (1e9 / userHz)
+	s.Cpu.Usage.Kernel = cpuacct["system"] * (1e9 / userHz)
+	cpu := readCgroupKV(dir("cpu", "cpu.stat"))
+	s.Cpu.Throttling.Periods = cpu["nr_periods"]
+	s.Cpu.Throttling.ThrottledPeriods = cpu["nr_throttled"]
+	s.Cpu.Throttling.ThrottledTime = cpu["throttled_time"]
+
+	s.Memory.Raw = readCgroupKV(dir("memory", "memory.stat"))
+	s.Memory.Cache = s.Memory.Raw["cache"]
+	memory := func(prefix string) MemoryEntry {
+		return MemoryEntry{
+			Usage:   readCgroupUint(dir("memory", prefix+".usage_in_bytes")),
+			Limit:   readCgroupUint(dir("memory", prefix+".limit_in_bytes")),
+			Max:     readCgroupUint(dir("memory", prefix+".max_usage_in_bytes")),
+			Failcnt: readCgroupUint(dir("memory", prefix+".failcnt")),
+		}
+	}
+	s.Memory.Usage = memory("memory")
+	s.Memory.Swap = memory("memory.memsw")
+	s.Memory.Kernel = memory("memory.kmem")
+	s.Memory.KernelTCP = memory("memory.kmem.tcp")
+
+	s.Pids.Current = readCgroupUint(dir("pids", "pids.current"))
+	s.Pids.Limit = pidsLimit(readCgroupUint(dir("pids", "pids.max")))
+
+	s.Blkio.IoServiceBytesRecursive = readBlkioEntries(dir("blkio", "blkio.throttle.io_service_bytes"))
+	s.Blkio.IoServicedRecursive = readBlkioEntries(dir("blkio", "blkio.throttle.io_serviced"))
+
+	for controller, section := range map[string]StatsSection{
+		"cpuacct": StatsCpu,
+		"memory":  StatsMemory,
+		"pids":    StatsPids,
+		"blkio":   StatsBlkio,
+	} {
+		if dir(controller, "") != "" {
+			s.Present |= section
+		}
+	}
+	return &s
+}
+
+// readBlkioEntries reads the "major:minor op value" lines of a cgroup v1
+// blkio file, skipping the total
+func readBlkioEntries(path string) []BlkioEntry {
+	data, ok := readCgroupString(path)
+	if !ok {
+		return nil
+	}
+	var entries []BlkioEntry
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if e, ok := blkioEntry(fields[0], fields[1], fields[2]); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// readIoStat reads the bytes and the operations of each device from the
+// cgroup v2 io.stat file, as runc reports them
+func readIoStat(path string) (bytes, ios []BlkioEntry) {
+	data, ok := readCgroupString(path)
+	if !ok {
+		return nil, nil
+	}
+	for _, line := range strings.Split(data, "\n") {
+		// major:minor rbytes=1 wbytes=2 rios=3 wios=4 ...
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			var (
+				op   string
+				list *[]BlkioEntry
+			)
+			switch key {
+			case "rbytes":
+				op, list = "Read", &bytes
+			case "wbytes":
+				op, list = "Write", &bytes
+			case "rios":
+				op, list = "Read", &ios
+			case "wios":
+				op, list = "Write", &ios
+			default:
+				continue
+			}
+			if e, ok := blkioEntry(fields[0], op, value); ok {
+				*list = append(*list, e)
+			}
+		}
+	}
+	return bytes, ios
+}
+
+// blkioEntry parses the entry of the device given as major:minor
+func blkioEntry(device, op, value string) (BlkioEntry, bool) {
+	major, minor, ok := strings.Cut(device, ":")
+	if !ok {
+		return BlkioEntry{}, false
+	}
+	var (
+		e    = BlkioEntry{Op: op}
+		errs [3]error
+	)
+	e.Major, errs[0] = strconv.ParseUint(major, 10, 64)
+	e.Minor, errs[1] = strconv.ParseUint(minor, 10, 64)
+	e.Value, errs[2] = strconv.ParseUint(value, 10, 64)
+	for _, err := range errs {
+		if err != nil {
+			return BlkioEntry{}, false
+		}
+	}
+	return e, true
+}
+
+// processResources reads the resource limits of the process cgroup
+func processResources(pid int) (*specs.LinuxResources, error) {
+	paths, err := processCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		return cgroupV2Resources(filepath.Join(cgroupRoot, path)), nil
+	}
+	return cgroupV1Resources(paths), nil
+}
+
+func cgroupV2Resources(dir string) *specs.LinuxResources {
+	var r specs.LinuxResources
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	if limit, ok := readCgroupLimit(file("memory.max")); ok {
+		r.Memory = &specs.LinuxMemory{Limit: &limit}
+		if low, ok := readCgroupLimit(file("memory.low")); ok {
+			r.Memory.Reservation = &low
+		}
+		// the swap limit of the spec is of memory+swap, as with cgroup v1
+		if swap, ok := readCgroupLimit(file("memory.swap.max")); ok {
+			if limit != -1 && swap != -1 {
+				swap += limit
+			} else {
+				swap = -1
+			}
+			r.Memory.Swap = &swap
+		}
+	}
+
+	var cpu specs.LinuxCPU
+	if v, ok := readCgroupString(file("cpu.weight")); ok {
+		if weight, err := strconv.ParseUint(v, 10, 64); err == nil && weight > 0 {
+			// the inverse of the conversion of runc from shares to weight
+			shares := 2 + (weight-1)*262142/9999
+			cpu.Shares = &shares
+		}
+	}
+	if v, ok := readCgroupString(file("cpu.max")); ok {
+		q, p, _ := strings.Cut(v, " ")
+		quota := int64(-1)
+		if q != "max" {
+			quota, _ = strconv.ParseInt(q, 10, 64)
+		}
+		cpu.Quota = &quota
+		if period, err := strconv.ParseUint(p, 10, 64); err == nil {
+			cpu.Period = &period
+		}
+	}
+	cpu.Cpus, _ = readCgroupString(file("cpuset.cpus"))
+	cpu.Mems, _ = readCgroupString(file("cpuset.mems"))
+	if cpu != (specs.LinuxCPU{}) {
+		r.CPU = &cpu
+	}
+
+	if limit, ok := readCgroupLimit(file("pids.max")); ok {
+		r.Pids = &specs.LinuxPids{Limit: limit}
+	}
+	return &r
+}
+
+func cgroupV1Resources(paths map[string]string) *specs.LinuxResources {
+	var r specs.LinuxResources
+	file := func(controller, name string) string {
+		for controllers, path := range paths {
+			for _, c := range strings.Split(controllers, ",") {
+				if c == controller {
+					return filepath.Join(cgroupRoot, controllers, path, name)
+				}
+			}
+		}
+		return ""
+	}
+
+	if limit, ok := readCgroupLimit(file("memory", "memory.limit_in_bytes")); ok {
+		r.Memory = &specs.LinuxMemory{Limit: &limit}
+		if v, ok := readCgroupLimit(file("memory", "memory.soft_limit_in_bytes")); ok {
+			r.Memory.Reservation = &v
+		}
+		if v, ok := readCgroupLimit(file("memory", "memory.memsw.limit_in_bytes")); ok {
+			r.Memory.Swap = &v
+		}
+	}
+
+	var cpu specs.LinuxCPU
+	if v, ok := readCgroupString(file("cpu", "cpu.shares")); ok {
+		if shares, err := strconv.ParseUint(v, 10, 64); err == nil {
+			cpu.Shares = &shares
+		}
+	}
+	if quota, ok := readCgroupLimit(file("cpu", "cpu.cfs_quota_us")); ok {
+		cpu.Quota = &quota
+	}
+	if v, ok := readCgroupString(file("cpu", "cpu.cfs_period_us")); ok {
+		if period, err := strconv.ParseUint(v, 10, 64); err == nil {
+			cpu.Period = &period
+		}
+	}
+	cpu.Cpus, _ = readCgroupString(file("cpuset", "cpuset.cpus"))
+	cpu.Mems, _ = readCgroupString(file("cpuset", "cpuset.mems"))
+	if cpu != (specs.LinuxCPU{}) {
+		r.CPU = &cpu
+	}
+
+	if limit, ok := readCgroupLimit(file("pids", "pids.max")); ok {
+		r.Pids = &specs.LinuxPids{Limit: limit}
+	}
+	return &r
+}
+
+// processControllers returns the cgroup controllers available to the
+// process
+func processControllers(pid int) ([]string, error) {
+	paths, err := processCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		data, err := os.ReadFile(filepath.Join(cgroupRoot, path, "cgroup.controllers"))
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(string(data)), nil
+	}
+	var controllers []string
+	for hierarchy := range paths {
+		// the named hierarchies, like name=systemd, have no controllers
+		if hierarchy == "" || strings.HasPrefix(hierarchy, "name=") {
+			continue
+		}
+		controllers = append(controllers, strings.Split(hierarchy, ",")...)
+	}
+	sort.Strings(controllers)
+	return controllers, nil
+}
+
+// processFreezerState reads the state of the freezer of the process cgroup
+func processFreezerState(pid int) (FreezerState, error) {
+	paths, err := processCgroups(pid)
+	if err != nil {
+		return "", err
+	}
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		// cgroup.freeze is the requested state, cgroup.events tells
+		// whether it has been reached
+		dir := filepath.Join(cgroupRoot, path)
+		data, err := os.ReadFile(filepath.Join(dir, "cgroup.freeze"))
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(string(data)) != "1" {
+			return Thawed, nil
+		}
+		if readCgroupKV(filepath.Join(dir, "cgroup.events"))["frozen"] == 1 {
+			return Frozen, nil
+		}
+		return Freezing, nil
+	}
+	path, ok := paths["freezer"]
+	if !ok {
+		return "", fmt.Errorf("process %d has no freezer cgroup", pid)
+	}
+	data, err := os.ReadFile(filepath.Join(cgroupRoot, "freezer", path, "freezer.state"))
+	if err != nil {
+		return "", err
+	}
+	switch state := FreezerState(strings.TrimSpace(string(data))); state {
+	case Thawed, Freezing, Frozen:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown freezer state %q", state)
+	}
+}
+
+// readCgroupUints reads the space separated values of a cgroup file. Missing
+// or unreadable files are reported as no values, "max" as math.MaxUint64.
+func readCgroupUints(path string) []uint64 {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var out []uint64
+	for _, f := range strings.Fields(string(data)) {
+		out = append(out, parseCgroupUint(f))
+	}
+	return out
+}
+
+// readCgroupUint reads the single value of a cgroup file, 0 if it is missing
+func readCgroupUint(path string) uint64 {
+	if v := readCgroupUints(path); len(v) > 0 {
+		return v[0]
+	}
+	return 0
+}
+
+// readCgroupString reads a single value cgroup file, returning false if it
+// can't be read
+func readCgroupString(path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// readCgroupLimit reads a cgroup file holding a limit, reporting unlimited
+// as -1 like the spec. A cgroup v1 memory limit is unlimited when it is
+// beyond the maximum int64 rounded down to the page size.
+func readCgroupLimit(path string) (int64, bool) {
+	v, ok := readCgroupString(path)
+	if !ok {
+		return 0, false
+	}
+	if v == "max" {
+		return -1, true
+	}
+	limit, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if limit < 0 || limit >= math.MaxInt64&^(int64(os.Getpagesize())-1) {
+		return -1, true
+	}
+	return limit, true
+}
+
+// readCgroupKV reads a cgroup file of "key value" lines
+func readCgroupKV(path string) map[string]uint64 {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	out := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		if k, v, ok := strings.Cut(line, " "); ok {
+			out[k] = parseCgroupUint(v)
+		}
+	}
+	return out
+}
+
+func parseCgroupUint(s string) uint64 {
+	s = strings.TrimSpace(s)
+	// negative values mean unlimited with cgroup v1
+	if s == "max" || strings.HasPrefix(s, "-") {
+		return math.MaxUint64
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// addLimits adds the limits a and b, saturating to unlimited
+func addLimits(a, b uint64) uint64 {
+	if a == math.MaxUint64 || b == math.MaxUint64 || a+b < a {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// pidsLimit reports an unlimited number of pids as 0, as runc does
+func pidsLimit(v uint64) uint64 {
+	if v == math.MaxUint64 {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,319 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// newCgroupTree fabricates procfs and cgroupfs with the files provided,
+// relative to their root
+func newCgroupTree(t *testing.T, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	origProc, origCgroup := procRoot, cgroupRoot
+	procRoot, cgroupRoot = filepath.Join(root, "proc"), filepath.Join(root, "cgroup")
+	t.Cleanup(func() {
+		procRoot, cgroupRoot = origProc, origCgroup
+	})
+}
+
+// newNoEventsRunc returns a Runc whose `events` subcommand is not
+// implemented and whose container is running with pid 4242
+func newNoEventsRunc(t *testing.T) *Runc {
+	return &Runc{
+		Command: newDummyRunc(t, `
+case "$1" in
+state)
+	echo '{"id":"fake-id","pid":4242,"status":"running"}'
+	;;
+*)
+	echo "unknown command $1" >&2
+	exit 1
+	;;
+esac
+`),
+	}
+}
+
+func TestCgroupV2Stats(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/cgroup":                                      "0::/system.slice/fake-id.scope\n",
+		"cgroup/system.slice/fake-id.scope/cpu.stat":            "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\nnr_periods 10\nnr_throttled 2\nthrottled_usec 30\n",
+		"cgroup/system.slice/fake-id.scope/memory.current":      "4096\n",
+		"cgroup/system.slice/fake-id.scope/memory.max":          "8192\n",
+		"cgroup/system.slice/fake-id.scope/memory.peak":         "6144\n",
+		"cgroup/system.slice/fake-id.scope/memory.events":       "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"cgroup/system.slice/fake-id.scope/memory.stat":         "anon 1024\nfile 2048\n",
+		"cgroup/system.slice/fake-id.scope/memory.swap.current": "512\n",
+		"cgroup/system.slice/fake-id.scope/memory.swap.max":     "max\n",
+		"cgroup/system.slice/fake-id.scope/pids.current":        "3\n",
+		"cgroup/system.slice/fake-id.scope/pids.max":            "max\n",
+		"cgroup/system.slice/fake-id.scope/io.stat":             "8:0 rbytes=4096 wbytes=512 rios=2 wios=1 dbytes=0 dios=0\n",
+	})
+
+	s, err := newNoEventsRunc(t).Stats(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from Stats: %s", err)
+	}
+	expected := &Stats{
+		Cpu: Cpu{
+			Usage:      CpuUsage{Total: 1500000, User: 1000000, Kernel: 500000},
+			Throttling: Throttling{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 30000},
+		},
+		Memory: Memory{
+			Cache: 2048,
+			Usage: MemoryEntry{Usage: 4096, Limit: 8192, Max: 6144, Failcnt: 3},
+			Swap:  MemoryEntry{Usage: 4608, Limit: math.MaxUint64},
+			Raw:   map[string]uint64{"anon": 1024, "file": 2048},
+		},
+		Pids: Pids{Current: 3},
+		Blkio: Blkio{
+			IoServiceBytesRecursive: []BlkioEntry{{Major: 8, Op: "Read", Value: 4096}, {Major: 8, Op: "Write", Value: 512}},
+			IoServicedRecursive:     []BlkioEntry{{Major: 8, Op: "Read", Value: 2}, {Major: 8, Op: "Write", Value: 1}},
+		},
+		Present: StatsCpu | StatsMemory | StatsPids | StatsBlkio,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected stats %+v but got %+v", expected, s)
+	}
+}
+
+func TestCgroupV1Stats(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/cgroup":                                "12:pids:/fake-id\n4:cpu,cpuacct:/fake-id\n3:memory:/fake-id\n1:name=systemd:/fake-id\n",
+		"cgroup/cpu,cpuacct/fake-id/cpuacct.usage":        "1500\n",
+		"cgroup/cpu,cpuacct/fake-id/cpuacct.usage_percpu": "1000 500 \n",
+		"cgroup/cpu,cpuacct/fake-id/cpuacct.stat":         "user 2\nsystem 1\n",
+		"cgroup/cpu,cpuacct/fake-id/cpu.stat":             "nr_periods 10\nnr_throttled 2\nthrottled_time 30\n",
+		"cgroup/memory/fake-id/memory.usage_in_bytes":     "4096\n",
+		"cgroup/memory/fake-id/memory.limit_in_bytes":     "9223372036854771712\n",
+		"cgroup/memory/fake-id/memory.max_usage_in_bytes": "6144\n",
+		"cgroup/memory/fake-id/memory.failcnt":            "1\n",
+		"cgroup/memory/fake-id/memory.stat":               "cache 2048\nrss 1024\n",
+		"cgroup/pids/fake-id/pids.current":                "3\n",
+		"cgroup/pids/fake-id/pids.max":                    "64\n",
+	})
+	// a host without the blkio controller, left out of the stats
+
+	s, err := newNoEventsRunc(t).Stats(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from Stats: %s", err)
+	}
+	expected := &Stats{
+		Cpu: Cpu{
+			Usage:      CpuUsage{Total: 1500, Percpu: []uint64{1000, 500}, User: 20000000, Kernel: 10000000},
+			Throttling: Throttling{Periods: 10, ThrottledPeriods: 2, ThrottledTime: 30},
+		},
+		Memory: Memory{
+			Cache: 2048,
+			Usage: MemoryEntry{Usage: 4096, Limit: 9223372036854771712, Max: 6144, Failcnt: 1},
+			Raw:   map[string]uint64{"cache": 2048, "rss": 1024},
+		},
+		Pids:    Pids{Current: 3, Limit: 64},
+		Present: StatsCpu | StatsMemory | StatsPids,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected stats %+v but got %+v", expected, s)
+	}
+}
+
+func TestCgroupV1StatsBlkio(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/cgroup": "5:blkio:/fake-id\n",
+		"cgroup/blkio/fake-id/blkio.throttle.io_service_bytes": "8:0 Read 4096\n8:0 Write 512\nTotal 4608\n",
+		"cgroup/blkio/fake-id/blkio.throttle.io_serviced":      "8:0 Read 2\n8:0 Write 1\nTotal 3\n",
+	})
+
+	s, err := newNoEventsRunc(t).Stats(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatalf("Unexpected error from Stats: %s", err)
+	}
+	if s.Present != StatsBlkio {
+		t.Fatalf("expected only blkio to be present, got %b", s.Present)
+	}
+	expected := Blkio{
+		IoServiceBytesRecursive: []BlkioEntry{{Major: 8, Op: "Read", Value: 4096}, {Major: 8, Op: "Write", Value: 512}},
+		IoServicedRecursive:     []BlkioEntry{{Major: 8, Op: "Read", Value: 2}, {Major: 8, Op: "Write", Value: 1}},
+	}
+	if !reflect.DeepEqual(s.Blkio, expected) {
+		t.Fatalf("expected blkio %+v but got %+v", expected, s.Blkio)
+	}
+}
+
+func TestStatsNoCgroup(t *testing.T) {
+	newCgroupTree(t, nil)
+	_, err := newNoEventsRunc(t).Stats(context.Background(), "fake-id")
+	if err == nil {
+		t.Fatal("expected an error without events nor cgroup")
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected the events error, got %v", err)
+	}
+}
+
+func TestFreezerState(t *testing.T) {
+	for _, tc := range []struct {
+		files    map[string]string
+		expected FreezerState
+	}{
+		{map[string]string{
+			"proc/4242/cgroup":             "0::/fake-id\n",
+			"cgroup/fake-id/cgroup.freeze": "0\n",
+			"cgroup/fake-id/cgroup.events": "populated 1\nfrozen 0\n",
+		}, Thawed},
+		{map[string]string{
+			"proc/4242/cgroup":             "0::/fake-id\n",
+			"cgroup/fake-id/cgroup.freeze": "1\n",
+			"cgroup/fake-id/cgroup.events": "populated 1\nfrozen 0\n",
+		}, Freezing},
+		{map[string]string{
+			"proc/4242/cgroup":             "0::/fake-id\n",
+			"cgroup/fake-id/cgroup.freeze": "1\n",
+			"cgroup/fake-id/cgroup.events": "populated 1\nfrozen 1\n",
+		}, Frozen},
+		{map[string]string{
+			"proc/4242/cgroup":                     "7:freezer:/fake-id\n3:memory:/fake-id\n",
+			"cgroup/freezer/fake-id/freezer.state": "THAWED\n",
+		}, Thawed},
+		{map[string]string{
+			"proc/4242/cgroup":                     "7:freezer:/fake-id\n3:memory:/fake-id\n",
+			"cgroup/freezer/fake-id/freezer.state": "FREEZING\n",
+		}, Freezing},
+		{map[string]string{
+			"proc/4242/cgroup":                     "7:freezer:/fake-id\n3:memory:/fake-id\n",
+			"cgroup/freezer/fake-id/freezer.state": "FROZEN\n",
+		}, Frozen},
+	} {
+		newCgroupTree(t, tc.files)
+		state, err := newNoEventsRunc(t).FreezerState(context.Background(), "fake-id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != tc.expected {
+			t.Errorf("expected freezer state %s, got %s with %v", tc.expected, state, tc.files)
+		}
+	}
+}
+
+func TestAvailableControllers(t *testing.T) {
+	for _, tc := range []struct {
+		files    map[string]string
+		expected []string
+	}{
+		{map[string]string{
+			"proc/4242/cgroup":                  "0::/fake-id\n",
+			"cgroup/fake-id/cgroup.controllers": "cpuset cpu io memory pids\n",
+		}, []string{"cpuset", "cpu", "io", "memory", "pids"}},
+		{map[string]string{
+			"proc/4242/cgroup": "12:pids:/fake-id\n4:cpu,cpuacct:/fake-id\n1:name=systemd:/fake-id\n0::/fake-id\n",
+		}, []string{"cpu", "cpuacct", "pids"}},
+	} {
+		newCgroupTree(t, tc.files)
+		controllers, err := newNoEventsRunc(t).AvailableControllers(context.Background(), "fake-id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(controllers, tc.expected) {
+			t.Errorf("expected controllers %v, got %v", tc.expected, controllers)
+		}
+	}
+}
+
+// resourcesJSON formats the resources with the values of the pointers
+func resourcesJSON(r *specs.LinuxResources) string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
+
+func TestCgroupV2Resources(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/cgroup":               "0::/fake-id\n",
+		"cgroup/fake-id/memory.max":      "1073741824\n",
+		"cgroup/fake-id/memory.low":      "536870912\n",
+		"cgroup/fake-id/memory.swap.max": "max\n",
+		"cgroup/fake-id/cpu.weight":      "10000\n",
+		"cgroup/fake-id/cpu.max":         "50000 100000\n",
+		"cgroup/fake-id/cpuset.cpus":     "0-1\n",
+		"cgroup/fake-id/pids.max":        "64\n",
+	})
+	r, err := newNoEventsRunc(t).ReadResources(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		limit, low, swap int64  = 1073741824, 536870912, -1
+		shares, period   uint64 = 262144, 100000
+		quota            int64  = 50000
+	)
+	expected := &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{Limit: &limit, Reservation: &low, Swap: &swap},
+		CPU:    &specs.LinuxCPU{Shares: &shares, Quota: &quota, Period: &period, Cpus: "0-1"},
+		Pids:   &specs.LinuxPids{Limit: 64},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("expected resources %s but got %s", resourcesJSON(expected), resourcesJSON(r))
+	}
+}
+
+func TestCgroupV1Resources(t *testing.T) {
+	newCgroupTree(t, map[string]string{
+		"proc/4242/cgroup": "12:pids:/fake-id\n4:cpu,cpuacct:/fake-id\n3:memory:/fake-id\n",
+		"cgroup/memory/fake-id/memory.limit_in_bytes":      "9223372036854771712\n",
+		"cgroup/memory/fake-id/memory.soft_limit_in_bytes": "268435456\n",
+		"cgroup/cpu,cpuacct/fake-id/cpu.shares":            "512\n",
+		"cgroup/cpu,cpuacct/fake-id/cpu.cfs_quota_us":      "-1\n",
+		"cgroup/cpu,cpuacct/fake-id/cpu.cfs_period_us":     "100000\n",
+		"cgroup/pids/fake-id/pids.max":                     "max\n",
+	})
+	r, err := newNoEventsRunc(t).ReadResources(context.Background(), "fake-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		limit, reservation int64  = -1, 268435456
+		shares, period     uint64 = 512, 100000
+		quota              int64  = -1
+	)
+	expected := &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{Limit: &limit, Reservation: &reservation},
+		CPU:    &specs.LinuxCPU{Shares: &shares, Quota: &quota, Period: &period},
+		Pids:   &specs.LinuxPids{Limit: -1},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("expected resources %s but got %s", resourcesJSON(expected), resourcesJSON(r))
+	}
+}
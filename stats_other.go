@@ -0,0 +1,42 @@
+//go:build !linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"errors"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func (r *Runc) cgroupStats(context context.Context, id string) (*Stats, error) {
+	return nil, errors.New("reading the cgroup statistics is only supported on linux")
+}
+
+func processFreezerState(pid int) (FreezerState, error) {
+	return "", errors.New("reading the freezer state is only supported on linux")
+}
+
+func processControllers(pid int) ([]string, error) {
+	return nil, errors.New("reading the cgroup controllers is only supported on linux")
+}
+
+func processResources(pid int) (*specs.LinuxResources, error) {
+	return nil, errors.New("reading the cgroup resources is only supported on linux")
+}
@@ -0,0 +1,199 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ResolveUser resolves the user, given as "user[:group]" where user and group
+// are names or numeric ids, with the /etc/passwd and /etc/group files of the
+// rootfs. Numeric ids missing from the files are used as is, a numeric user
+// missing from the passwd file getting the gid 0 as with runc.
+//
+// The files are resolved within the rootfs, symlinks included, so that a
+// rootfs linking them elsewhere cannot make the host files be read.
+//
+// With groups, the supplementary groups listing the user as a member are
+// resolved as well.
+func ResolveUser(rootfs, user string, groups bool) (specs.User, error) {
+	var u specs.User
+	name, group, hasGroup := strings.Cut(user, ":")
+	passwd, err := readRootfsFile(rootfs, "/etc/passwd")
+	if err != nil {
+		return u, err
+	}
+	uid, uidErr := strconv.ParseUint(name, 10, 32)
+	found := false
+	for _, e := range passwd {
+		// name:password:uid:gid:gecos:home:shell
+		if len(e) < 4 {
+			continue
+		}
+		if e[0] == name || (uidErr == nil && e[2] == name) {
+			id, err := strconv.ParseUint(e[2], 10, 32)
+			if err != nil {
+				continue
+			}
+			gid, err := strconv.ParseUint(e[3], 10, 32)
+			if err != nil {
+				continue
+			}
+			name, u.UID, u.GID, found = e[0], uint32(id), uint32(gid), true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return u, fmt.Errorf("no user %q in the passwd file of %s", name, rootfs)
+		}
+		u.UID, u.GID = uint32(uid), 0
+	}
+
+	if !hasGroup && !groups {
+		return u, nil
+	}
+	entries, err := readRootfsFile(rootfs, "/etc/group")
+	if err != nil {
+		return u, err
+	}
+	if hasGroup {
+		gid, gidErr := strconv.ParseUint(group, 10, 32)
+		found := false
+		for _, e := range entries {
+			// name:password:gid:members
+			if len(e) < 3 {
+				continue
+			}
+			if e[0] == group || (gidErr == nil && e[2] == group) {
+				id, err := strconv.ParseUint(e[2], 10, 32)
+				if err != nil {
+					continue
+				}
+				u.GID, found = uint32(id), true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return u, fmt.Errorf("no group %q in the group file of %s", group, rootfs)
+			}
+			u.GID = uint32(gid)
+		}
+	}
+	if groups {
+		for _, e := range entries {
+			if len(e) < 4 {
+				continue
+			}
+			id, err := strconv.ParseUint(e[2], 10, 32)
+			if err != nil || uint32(id) == u.GID {
+				continue
+			}
+			for _, m := range strings.Split(e[3], ",") {
+				if m == name {
+					u.AdditionalGids = append(u.AdditionalGids, uint32(id))
+					break
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+// maxSymlinks is the number of symlinks followed when resolving a path within
+// a rootfs, as with the kernel
+const maxSymlinks = 40
+
+// rootfsJoin resolves the path within the rootfs, following symlinks as if
+// the rootfs was the root: neither ".." nor absolute links can escape it.
+// Components past the first missing one are joined as is.
+func rootfsJoin(rootfs, path string) (string, error) {
+	current, links := "/", 0
+	for path != "" {
+		var part string
+		part, path, _ = strings.Cut(path, "/")
+		if part == "" || part == "." {
+			continue
+		}
+		next := filepath.Join(current, part)
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.Join(rootfs, filepath.Join(next, path)), nil
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: filepath.Join(rootfs, next), Err: syscall.ELOOP}
+		}
+		dest, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			current = "/"
+		}
+		path = dest + "/" + path
+	}
+	return filepath.Join(rootfs, current), nil
+}
+
+// readRootfsFile reads the entries of the passwd or group file at the path
+// within the rootfs
+func readRootfsFile(rootfs, path string) ([][]string, error) {
+	resolved, err := rootfsJoin(rootfs, path)
+	if err != nil {
+		return nil, err
+	}
+	return readColonFile(resolved)
+}
+
+// readColonFile reads the colon separated entries of a passwd or group file.
+// A missing file has no entries.
+func readColonFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var out [][]string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, strings.Split(line, ":"))
+	}
+	return out, s.Err()
+}
@@ -0,0 +1,161 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runc
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// newTestRootfs creates a rootfs with sample passwd and group files
+func newTestRootfs(t *testing.T) string {
+	t.Helper()
+	rootfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := `root:x:0:0:root:/root:/bin/sh
+# comment
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+app:x:1000:1000::/home/app:/bin/sh
+`
+	group := `root:x:0:
+adm:x:4:app
+www-data:x:33:app,www-data
+app:x:1000:app
+docker:x:999:other,app
+`
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "passwd"), []byte(passwd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "group"), []byte(group), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return rootfs
+}
+
+func TestResolveUser(t *testing.T) {
+	rootfs := newTestRootfs(t)
+	for _, tc := range []struct {
+		user     string
+		groups   bool
+		expected specs.User
+	}{
+		{"app", false, specs.User{UID: 1000, GID: 1000}},
+		{"app", true, specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{4, 33, 999}}},
+		{"1000", true, specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{4, 33, 999}}},
+		{"app:docker", true, specs.User{UID: 1000, GID: 999, AdditionalGids: []uint32{4, 33, 1000}}},
+		{"www-data:0", false, specs.User{UID: 33, GID: 0}},
+		{"4242:4343", true, specs.User{UID: 4242, GID: 4343}},
+		{"4242", false, specs.User{UID: 4242, GID: 0}},
+	} {
+		u, err := ResolveUser(rootfs, tc.user, tc.groups)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.user, err)
+		}
+		if !reflect.DeepEqual(u, tc.expected) {
+			t.Errorf("%s: expected %+v but got %+v", tc.user, tc.expected, u)
+		}
+	}
+	for _, user := range []string{"nobody", "app:nogroup"} {
+		if _, err := ResolveUser(rootfs, user, false); err == nil {
+			t.Errorf("%s: expected an error for a missing name", user)
+		}
+	}
+}
+
+func TestResolveUserSymlinks(t *testing.T) {
+	host := newTestRootfs(t)
+	rootfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// links to the files of the host must stay within the rootfs
+	if err := os.Symlink(filepath.Join(host, "etc", "passwd"), filepath.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(filepath.Join(rootfs, "etc"), filepath.Join(host, "etc", "group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(rel, filepath.Join(rootfs, "etc", "group")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveUser(rootfs, "app", false); err == nil {
+		t.Fatal("expected the passwd file of the host not to be read")
+	}
+	if u, err := ResolveUser(rootfs, "1000:docker", false); err == nil {
+		t.Fatalf("expected the group file of the host not to be read, got %+v", u)
+	}
+
+	// links within the rootfs are followed
+	if err := os.Rename(filepath.Join(host, "etc"), filepath.Join(rootfs, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/../data/passwd", filepath.Join(rootfs, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := ResolveUser(rootfs, "app", false); err != nil || u.UID != 1000 {
+		t.Fatalf("expected the linked passwd file to be read, got %+v, %v", u, err)
+	}
+}
+
+func TestRuncExecResolveGroups(t *testing.T) {
+	rootfs := newTestRootfs(t)
+	out := filepath.Join(t.TempDir(), "process.json")
+	r := &Runc{
+		Command: newDummyRunc(t, `
+if [ "$1" = "state" ]; then
+	echo '{"id":"fake-id","pid":4242,"status":"running","rootfs":"`+rootfs+`"}'
+	exit 0
+fi
+for arg; do
+	if [ "$prev" = "--process" ]; then
+		cp "$arg" `+out+`
+	fi
+	prev=$arg
+done
+`),
+	}
+	err := r.Exec(context.Background(), "fake-id", specs.Process{Args: []string{"id"}}, &ExecOpts{
+		User:          "app",
+		ResolveGroups: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from Exec: %s", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p specs.Process
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{4, 33, 999}}); !reflect.DeepEqual(p.User, expected) {
+		t.Fatalf("expected user %+v but got %+v", expected, p.User)
+	}
+}
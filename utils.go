@@ -18,6 +18,8 @@ package runc
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -34,6 +36,128 @@ func ReadPidFile(path string) (int, error) {
 	return strconv.Atoi(string(data))
 }
 
+// systemdRunDir only exists when the host has been booted with systemd, see
+// sd_booted(3)
+var systemdRunDir = "/run/systemd/system"
+
+// isRunningSystemd checks whether the host was booted with systemd as its
+// init system, in which case systemd is also the cgroup manager
+func isRunningSystemd() bool {
+	fi, err := os.Lstat(systemdRunDir)
+	return err == nil && fi.IsDir()
+}
+
+// compareVersions compares two dotted versions such as "1.0.2-dev",
+// returning -1, 0 or 1. Only the numeric components are compared, so
+// pre-release and build suffixes are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := versionNumbers(a), versionNumbers(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionNumbers(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	var out []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// mergeEnv returns base with the variables of extra merged onto it. A
+// variable of extra replaces the one of base with the same name in place,
+// others are appended in order.
+func mergeEnv(base, extra []string) []string {
+	out := make([]string, 0, len(base)+len(extra))
+	index := make(map[string]int, len(base)+len(extra))
+	for _, list := range [][]string{base, extra} {
+		for _, kv := range list {
+			k, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[k]; ok {
+				out[i] = kv
+				continue
+			}
+			index[k] = len(out)
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// ErrOutputTruncated is wrapped by errors whose captured output
+// exceeded the configured limit and was truncated
+var ErrOutputTruncated = errors.New("output truncated")
+
+// limitWriter writes at most n bytes to w and discards the rest
+type limitWriter struct {
+	w         io.Writer
+	n         int
+	truncated bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if len(p) <= l.n {
+		l.n -= len(p)
+		return l.w.Write(p)
+	}
+	l.truncated = true
+	if l.n > 0 {
+		if _, err := l.w.Write(p[:l.n]); err != nil {
+			return 0, err
+		}
+		l.n = 0
+	}
+	// report the whole write to not fail the copy from the process
+	return len(p), nil
+}
+
+// batchConcurrency bounds the number of runc commands run at once by the
+// methods operating on several containers
+const batchConcurrency = 8
+
+// forEachConcurrent calls fn for each id, with at most n calls running at
+// once, and returns when all of them have returned
+func forEachConcurrent(ids []string, n int, fn func(id string)) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, n)
+	)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(id)
+		}(id)
+	}
+	wg.Wait()
+}
+
 var bytesBufferPool = sync.Pool{
 	New: func() interface{} {
 		return bytes.NewBuffer(nil)